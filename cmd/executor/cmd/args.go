@@ -0,0 +1,43 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// multiArg is a flag value which can be set multiple times, collecting every value
+type multiArg []string
+
+// String returns all values of the flag, comma separated
+func (m *multiArg) String() string {
+	return strings.Join(*m, ",")
+}
+
+// Set appends value to the values of the flag
+func (m *multiArg) Set(value string) error {
+	logrus.Debugf("Appending %s to multi arg", value)
+	*m = append(*m, value)
+	return nil
+}
+
+// Type returns the name of the flag value type, for help output
+func (m *multiArg) Type() string {
+	return "multi-arg type"
+}
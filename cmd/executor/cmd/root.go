@@ -17,38 +17,92 @@ limitations under the License.
 package cmd
 
 import (
+	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/genuinetools/amicontained/container"
 
+	"github.com/GoogleCloudPlatform/kaniko/pkg/buildcontext"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/executor"
 
 	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/containers/image/types"
+	"github.com/docker/distribution/reference"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
 var (
 	dockerfilePath string
-	destination    string
+	destinations   multiArg
 	srcContext     string
 	snapshotMode   string
 	bucket         string
 	logLevel       string
+	logFormat      string
 	force          bool
+	buildArgs      multiArg
+	useCache       bool
+	cacheRepo      string
+	tarPath        string
+	ociLayoutPath  string
+	noPush         bool
+	reproducible   bool
+	dockerConfig   string
+	username       string
+	password       string
+	credentials    map[string]types.DockerAuthConfig
+	insecure       multiArg
+	certificates   multiArg
+	mirrors        multiArg
+	cacheDir       string
+	digestFile     string
+	nameFile       string
+	reportFile     string
+	gitOpts        util.GitOptions
+	s3Endpoint     string
+	dryRun         bool
+	target         string
 )
 
 func init() {
 	RootCmd.PersistentFlags().StringVarP(&dockerfilePath, "dockerfile", "f", "Dockerfile", "Path to the dockerfile to be built.")
-	RootCmd.PersistentFlags().StringVarP(&srcContext, "context", "c", "", "Path to the dockerfile build context.")
-	RootCmd.PersistentFlags().StringVarP(&bucket, "bucket", "b", "", "Name of the GCS bucket from which to access build context as tarball.")
-	RootCmd.PersistentFlags().StringVarP(&destination, "destination", "d", "", "Registry the final image should be pushed to (ex: gcr.io/test/example:latest)")
-	RootCmd.PersistentFlags().StringVarP(&snapshotMode, "snapshotMode", "", "full", "Set this flag to change the file attributes inspected during snapshotting")
+	RootCmd.PersistentFlags().StringVarP(&srcContext, "context", "c", "", "Path to the dockerfile build context, or a tarball of it at gs://<bucket>/<path>, s3://<bucket>/<path>, an http(s):// URL or tar://stdin, or a git repository to clone as git://<repository>#<ref>:<subdirectory>.")
+	RootCmd.PersistentFlags().StringVarP(&gitOpts.SSHKey, "git-ssh-key", "", "", "Private key to clone git build contexts over SSH with. A known_hosts file next to it is used to verify the server.")
+	RootCmd.PersistentFlags().StringVarP(&gitOpts.TokenFile, "git-token-file", "", "", "File containing a token to clone git build contexts over HTTPS with")
+	RootCmd.PersistentFlags().StringVarP(&gitOpts.Username, "git-username", "", "oauth2", "Username to clone git build contexts over HTTPS with, used with --git-token-file")
+	RootCmd.PersistentFlags().BoolVarP(&gitOpts.RecurseSubmodules, "git-recurse-submodules", "", false, "Check out the submodules of git build contexts")
+	RootCmd.PersistentFlags().StringVarP(&bucket, "bucket", "b", "", "Name of the GCS bucket from which to access build context as tarball, named context.tar.gz.")
+	RootCmd.PersistentFlags().StringVarP(&s3Endpoint, "s3-endpoint", "", "", "URL of the S3-compatible store, such as MinIO, to read s3:// build contexts from. Defaults to Amazon S3")
+	RootCmd.PersistentFlags().VarP(&destinations, "destination", "d", "Registry the final image should be pushed to (ex: gcr.io/test/example:latest). Set it repeatedly for multiple destinations.")
+	RootCmd.PersistentFlags().VarP(&buildArgs, "build-arg", "", "This flag allows you to pass in ARG values at build time. Set it repeatedly for multiple values.")
+	RootCmd.PersistentFlags().StringVarP(&snapshotMode, "snapshotMode", "", "full", "Set this flag to change the file attributes inspected during snapshotting (full, time or trace)")
 	RootCmd.PersistentFlags().StringVarP(&logLevel, "verbosity", "v", constants.DefaultLogLevel, "Log level (debug, info, warn, error, fatal, panic")
+	RootCmd.PersistentFlags().StringVarP(&logFormat, "log-format", "", constants.LogFormatText, "Format of the logs, text or json")
 	RootCmd.PersistentFlags().BoolVarP(&force, "force", "", false, "Force building outside of a container")
+	RootCmd.PersistentFlags().BoolVarP(&useCache, "cache", "", false, "Use cached layers for RUN, ADD and COPY commands, and cache the layers they create")
+	RootCmd.PersistentFlags().StringVarP(&cacheRepo, "cache-repo", "", "", "Repository to store cached layers in, defaults to <destination repository>/cache")
+	RootCmd.PersistentFlags().StringVarP(&tarPath, "tarPath", "", "", "Path to save the image to as a tarball, which can be loaded with docker load")
+	RootCmd.PersistentFlags().StringVarP(&ociLayoutPath, "oci-layout-path", "", "", "Path to save the image to as an OCI image layout")
+	RootCmd.PersistentFlags().BoolVarP(&noPush, "no-push", "", false, "Do not push the image to the registry")
+	RootCmd.PersistentFlags().StringVarP(&dockerConfig, "docker-config", "", "", "Docker config file, or directory containing one such as a mounted .dockerconfigjson secret, to read registry credentials from. Defaults to $DOCKER_CONFIG/config.json or $HOME/.docker/config.json")
+	RootCmd.PersistentFlags().StringVarP(&username, "registry-username", "", "", "Username for the registries of the destinations and cache repository, or of the images to warm, overriding the docker config")
+	RootCmd.PersistentFlags().StringVarP(&password, "registry-password", "", "", "Password for the registries of the destinations and cache repository, or of the images to warm, used with --registry-username")
+	RootCmd.PersistentFlags().VarP(&insecure, "insecure-registry", "", "Registry to access over HTTP, or over HTTPS without verifying its certificate. Set it repeatedly for multiple registries.")
+	RootCmd.PersistentFlags().VarP(&certificates, "registry-certificate", "", "CA certificate, or directory of certificates, to verify a registry with, as <registry>=<path>. Set it repeatedly for multiple registries.")
+	RootCmd.PersistentFlags().VarP(&mirrors, "registry-mirror", "", "Mirror to pull base images from before trying their registry, as <mirror> for Docker Hub or <registry>=<mirror>. Set it repeatedly for multiple mirrors, which are tried in order.")
+	RootCmd.PersistentFlags().StringVarP(&cacheDir, "cache-dir", "", "", "Directory, such as a persistent volume, to store the blobs of base images in so that later builds don't download them again. Defaults to /kaniko")
+	RootCmd.PersistentFlags().StringVarP(&digestFile, "digest-file", "", "", "Path to write the digest of the image to")
+	RootCmd.PersistentFlags().StringVarP(&nameFile, "image-name-with-digest-file", "", "", "Path to write each destination of the image to, referred to by digest, one per line")
+	RootCmd.PersistentFlags().StringVarP(&reportFile, "build-report", "", "", "Path to write a JSON report to, describing the destinations, digests and layers of the image and the digest of its base image")
+	RootCmd.PersistentFlags().StringVarP(&target, "target", "", "", "Name of the stage to build, instead of the last stage. Only the stages it depends on are built.")
+	RootCmd.PersistentFlags().BoolVarP(&dryRun, "dry-run", "", false, "Check the Dockerfile and print a JSON plan of how it would be built, without building it")
+	RootCmd.PersistentFlags().BoolVarP(&reproducible, "reproducible", "", false, "Strip timestamps from the image, so that building the same context twice produces the same image")
 }
 
 var RootCmd = &cobra.Command{
@@ -57,12 +111,37 @@ var RootCmd = &cobra.Command{
 		if err := util.SetLogLevel(logLevel); err != nil {
 			return err
 		}
+		if err := util.SetLogFormat(logFormat); err != nil {
+			return err
+		}
 		if err := resolveSourceContext(); err != nil {
 			return err
 		}
+		// A dry run doesn't save the image, or push layers to the cache
+		if !dryRun {
+			if err := checkOutputs(); err != nil {
+				return err
+			}
+			if err := resolveCacheRepo(); err != nil {
+				return err
+			}
+		}
+		if err := resolveCredentials(pushRepos()); err != nil {
+			return err
+		}
+		if err := checkRegistryCertificates(); err != nil {
+			return err
+		}
 		return checkDockerfilePath()
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		if dryRun {
+			if err := printPlan(); err != nil {
+				logrus.Error(err)
+				os.Exit(1)
+			}
+			return
+		}
 		if !checkContained() {
 			if !force {
 				logrus.Error("kaniko should only be run inside of a container, run with the --force flag if you are sure you want to continue.")
@@ -70,13 +149,49 @@ var RootCmd = &cobra.Command{
 			}
 			logrus.Warn("kaniko is being run outside of a container. This can have dangerous effects on your system")
 		}
-		if err := executor.DoBuild(dockerfilePath, srcContext, destination, snapshotMode); err != nil {
+		if err := executor.DoBuild(kanikoBuildArgs()); err != nil {
 			logrus.Error(err)
 			os.Exit(1)
 		}
 	},
 }
 
+// kanikoBuildArgs returns the arguments of the build given by the flags
+func kanikoBuildArgs() executor.KanikoBuildArgs {
+	return executor.KanikoBuildArgs{
+		DockerfilePath:          dockerfilePath,
+		SrcContext:              srcContext,
+		Destinations:            destinations,
+		SnapshotMode:            snapshotMode,
+		Args:                    buildArgs,
+		Cache:                   useCache,
+		CacheRepo:               cacheRepo,
+		TarPath:                 tarPath,
+		OCILayoutPath:           ociLayoutPath,
+		NoPush:                  noPush,
+		Reproducible:            reproducible,
+		Registry:                registryOptions(),
+		CacheDir:                cacheDir,
+		DigestFile:              digestFile,
+		ImageNameWithDigestFile: nameFile,
+		BuildReportFile:         reportFile,
+		Target:                  target,
+	}
+}
+
+// printPlan prints the plan of the build as JSON, and returns an error if there are problems with the Dockerfile
+func printPlan() error {
+	plan, err := executor.DoPlan(kanikoBuildArgs())
+	if plan != nil {
+		b, jsonErr := json.MarshalIndent(plan, "", "  ")
+		if jsonErr != nil {
+			return jsonErr
+		}
+		fmt.Println(string(b))
+	}
+	return err
+}
+
 func checkContained() bool {
 	_, err := container.DetectRuntime()
 	return err == nil
@@ -94,8 +209,8 @@ func checkDockerfilePath() error {
 	return errors.New("please provide a valid path to a Dockerfile within the build context")
 }
 
-// resolveSourceContext unpacks the source context if it is a tar in a GCS bucket
-// it resets srcContext to be the path to the unpacked build context within the image
+// resolveSourceContext makes the build context available locally, unpacking or cloning it into the build context
+// directory unless it is a local directory, and resets srcContext to be the path to the build context within the image
 func resolveSourceContext() error {
 	if srcContext == "" && bucket == "" {
 		return errors.New("please specify a path to the build context with the --context flag or a GCS bucket with the --bucket flag")
@@ -103,15 +218,124 @@ func resolveSourceContext() error {
 	if srcContext != "" && bucket != "" {
 		return errors.New("please specify either --bucket or --context as the desired build context")
 	}
-	if srcContext != "" {
-		return nil
+	if bucket != "" {
+		srcContext = "gs://" + strings.TrimPrefix(bucket, "gs://") + "/" + constants.ContextTar
 	}
-	logrus.Infof("Using GCS bucket %s as source context", bucket)
-	buildContextPath := constants.BuildContextDir
-	if err := util.UnpackTarFromGCSBucket(bucket, buildContextPath); err != nil {
+	buildContext, err := buildcontext.GetBuildContext(srcContext, buildcontext.Options{
+		Git:        gitOpts,
+		S3Endpoint: s3Endpoint,
+	})
+	if err != nil {
 		return err
 	}
-	logrus.Debugf("Unpacked tar from %s to path %s", bucket, buildContextPath)
-	srcContext = buildContextPath
+	if srcContext, err = buildContext.Unpack(constants.BuildContextDir); err != nil {
+		return err
+	}
+	logrus.Debugf("Using build context %s", srcContext)
+	return nil
+}
+
+// checkOutputs makes sure the image will be saved somewhere, and that a destination is given if it is needed
+func checkOutputs() error {
+	if !noPush && len(destinations) == 0 {
+		return errors.New("please specify a destination to push the image to with the --destination flag, or use --no-push")
+	}
+	if tarPath != "" && len(destinations) == 0 {
+		return errors.New("please specify a destination with the --destination flag, which is used to tag the image in the tarball")
+	}
+	if noPush && tarPath == "" && ociLayoutPath == "" {
+		logrus.Warn("The image will not be pushed or saved, since --no-push is set without --tarPath or --oci-layout-path")
+	}
+	return nil
+}
+
+// resolveCacheRepo sets the cache repo to <first destination repository>/cache if caching is enabled and no repo was specified
+func resolveCacheRepo() error {
+	if !useCache || cacheRepo != "" {
+		return nil
+	}
+	if len(destinations) == 0 {
+		return errors.New("please specify a repository for cached layers with the --cache-repo flag")
+	}
+	ref, err := reference.ParseNormalizedNamed(destinations[0])
+	if err != nil {
+		return errors.New("please specify a repository for cached layers with the --cache-repo flag")
+	}
+	cacheRepo = ref.Name() + "/cache"
+	return nil
+}
+
+// pushRepos returns the repositories the image and cached layers are pushed to
+func pushRepos() []string {
+	repos := append([]string{}, destinations...)
+	if useCache {
+		repos = append(repos, cacheRepo)
+	}
+	return repos
+}
+
+// resolveCredentials scopes the username and password to the registries of repos, which are the registries
+// they are meant for, so that they aren't sent to the registries of other images
+func resolveCredentials(repos []string) error {
+	if (username == "") != (password == "") {
+		return errors.New("please specify both --registry-username and --registry-password, or neither")
+	}
+	if username == "" {
+		return nil
+	}
+	credentials = map[string]types.DockerAuthConfig{}
+	for _, repo := range repos {
+		ref, err := reference.ParseNormalizedNamed(repo)
+		if err != nil {
+			return err
+		}
+		credentials[reference.Domain(ref)] = types.DockerAuthConfig{Username: username, Password: password}
+	}
 	return nil
 }
+
+// registryOptions returns the options for accessing registries given by the registry flags
+func registryOptions() util.RegistryOptions {
+	return util.RegistryOptions{
+		DockerConfig:       dockerConfig,
+		Credentials:        credentials,
+		InsecureRegistries: insecure,
+		Certificates:       registryCertificates(),
+		Mirrors:            registryMirrors(),
+	}
+}
+
+// checkRegistryCertificates makes sure that each --registry-certificate is given as <registry>=<path>
+func checkRegistryCertificates() error {
+	for _, cert := range certificates {
+		registryAndPath := strings.SplitN(cert, "=", 2)
+		if len(registryAndPath) != 2 || registryAndPath[0] == "" || registryAndPath[1] == "" {
+			return fmt.Errorf("please specify --registry-certificate as <registry>=<path>, got %s", cert)
+		}
+	}
+	return nil
+}
+
+// registryCertificates maps each registry given a certificate with --registry-certificate to the path of the certificate
+func registryCertificates() map[string]string {
+	certs := map[string]string{}
+	for _, cert := range certificates {
+		registryAndPath := strings.SplitN(cert, "=", 2)
+		certs[registryAndPath[0]] = registryAndPath[1]
+	}
+	return certs
+}
+
+// registryMirrors maps registries to the mirrors given for them with --registry-mirror, in order
+// Mirrors given without a registry are for Docker Hub.
+func registryMirrors() map[string][]string {
+	registryMirrors := map[string][]string{}
+	for _, mirror := range mirrors {
+		registry := "docker.io"
+		if registryAndMirror := strings.SplitN(mirror, "=", 2); len(registryAndMirror) == 2 {
+			registry, mirror = registryAndMirror[0], registryAndMirror[1]
+		}
+		registryMirrors[registry] = append(registryMirrors[registry], mirror)
+	}
+	return registryMirrors
+}
@@ -0,0 +1,67 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cmd
+
+import (
+	"errors"
+	"os"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+var images multiArg
+
+func init() {
+	WarmerCmd.Flags().VarP(&images, "image", "i", "Image to download to the cache directory. Set it repeatedly for multiple images.")
+	RootCmd.AddCommand(WarmerCmd)
+}
+
+// WarmerCmd downloads base images to the cache directory, so that builds using it don't need to pull them
+var WarmerCmd = &cobra.Command{
+	Use:   "warmer",
+	Short: "Download base images to the cache directory given by --cache-dir",
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		if err := util.SetLogLevel(logLevel); err != nil {
+			return err
+		}
+		if err := util.SetLogFormat(logFormat); err != nil {
+			return err
+		}
+		if len(images) == 0 {
+			return errors.New("please specify the images to download with the --image flag")
+		}
+		if err := resolveCredentials(images); err != nil {
+			return err
+		}
+		return checkRegistryCertificates()
+	},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := util.SetRegistryOptions(registryOptions()); err != nil {
+			logrus.Error(err)
+			os.Exit(1)
+		}
+		util.SetCacheDir(cacheDir)
+		for _, image := range images {
+			if _, err := util.PullImage(image); err != nil {
+				logrus.Errorf("Failed to download %s: %s", image, err)
+				os.Exit(1)
+			}
+		}
+	},
+}
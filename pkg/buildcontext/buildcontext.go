@@ -0,0 +1,113 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildcontext
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// BuildContext is a source of a build context
+type BuildContext interface {
+	// Unpack makes the build context available in directory, unless it is a local directory,
+	// and returns the path of the build context
+	Unpack(directory string) (string, error)
+}
+
+// Options configures how build contexts are retrieved
+type Options struct {
+	// Git configures how git repositories are cloned
+	Git util.GitOptions
+	// S3Endpoint is the URL of the S3-compatible store s3:// contexts are read from, or "" for Amazon S3
+	S3Endpoint string
+}
+
+// GetBuildContext returns the source of srcContext, which is chosen by its prefix:
+// gs:// for an object in a GCS bucket, s3:// for an object in an S3 bucket, http:// or https:// for a URL,
+// tar://stdin for standard input, git:// for a git repository, or no prefix for a local directory.
+// Contexts other than directories and git repositories are tarballs, which can be compressed.
+func GetBuildContext(srcContext string, opts Options) (BuildContext, error) {
+	switch {
+	case strings.HasPrefix(srcContext, gcsPrefix):
+		gcs, err := newGCS(srcContext)
+		if err != nil {
+			return nil, err
+		}
+		return gcs, nil
+	case strings.HasPrefix(srcContext, s3Prefix):
+		s3, err := newS3(srcContext, opts.S3Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return s3, nil
+	case strings.HasPrefix(srcContext, "https://") || strings.HasPrefix(srcContext, "http://"):
+		return &HTTP{url: srcContext}, nil
+	case srcContext == stdinContext:
+		return &Stdin{r: os.Stdin}, nil
+	case util.IsGitContext(srcContext):
+		context, err := util.ParseGitContext(srcContext)
+		if err != nil {
+			return nil, err
+		}
+		return &Git{context: context, opts: opts.Git}, nil
+	case strings.Contains(srcContext, "://"):
+		return nil, errors.Errorf("unsupported build context %s", srcContext)
+	}
+	return Dir(srcContext), nil
+}
+
+// Dir is a build context in a local directory
+type Dir string
+
+// Unpack returns the directory
+func (d Dir) Unpack(directory string) (string, error) {
+	return string(d), nil
+}
+
+// Git is a build context in a git repository
+type Git struct {
+	context util.GitContext
+	opts    util.GitOptions
+}
+
+// Unpack clones the repository into directory
+func (g *Git) Unpack(directory string) (string, error) {
+	return util.CloneGitContext(g.context, directory, g.opts)
+}
+
+// unpackTar saves the tarball r reads from in directory, and then unpacks it there
+// The tarball is saved to a file first, so that its compression can be detected.
+func unpackTar(r io.Reader, directory string) (string, error) {
+	tarPath := filepath.Join(directory, constants.ContextTar)
+	if err := util.CreateFile(tarPath, r, 0600); err != nil {
+		return "", err
+	}
+	logrus.Debugf("Unpacking build context %s", tarPath)
+	if err := util.UnpackLocalTarArchive(tarPath, directory); err != nil {
+		return "", errors.Wrap(err, "unpacking build context")
+	}
+	// Remove the tar so it doesn't interfere with subsequent commands
+	logrus.Debugf("Deleting %s", tarPath)
+	return directory, os.Remove(tarPath)
+}
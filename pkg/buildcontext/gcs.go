@@ -0,0 +1,72 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildcontext
+
+import (
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/context"
+	"google.golang.org/api/option"
+)
+
+const gcsPrefix = "gs://"
+
+// GCS is a build context tarball in a GCS bucket
+type GCS struct {
+	bucket string
+	object string
+	// opts are the options the storage client is created with
+	opts []option.ClientOption
+}
+
+// newGCS returns the build context in the GCS object gs://<bucket>/<object>
+func newGCS(srcContext string) (*GCS, error) {
+	bucket, object, err := splitBucketURL(srcContext, gcsPrefix)
+	if err != nil {
+		return nil, err
+	}
+	return &GCS{bucket: bucket, object: object}, nil
+}
+
+// Unpack downloads the tarball from the bucket and unpacks it into directory
+func (g *GCS) Unpack(directory string) (string, error) {
+	ctx := context.Background()
+	client, err := storage.NewClient(ctx, g.opts...)
+	if err != nil {
+		return "", err
+	}
+	defer client.Close()
+	logrus.Infof("Downloading build context from GCS object gs://%s/%s", g.bucket, g.object)
+	reader, err := client.Bucket(g.bucket).Object(g.object).NewReader(ctx)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading gs://%s/%s", g.bucket, g.object)
+	}
+	defer reader.Close()
+	return unpackTar(reader, directory)
+}
+
+// splitBucketURL splits a URL of the form <prefix><bucket>/<object> into the bucket and object
+func splitBucketURL(url, prefix string) (string, string, error) {
+	parts := strings.SplitN(strings.TrimPrefix(url, prefix), "/", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", errors.Errorf("please specify the build context as %s<bucket>/<path of tarball>, got %s", prefix, url)
+	}
+	return parts[0], parts[1], nil
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildcontext
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// HTTP is a build context tarball at an HTTP or HTTPS URL
+type HTTP struct {
+	url string
+	// client is the client the tarball is downloaded with, or nil for the default client
+	client *http.Client
+}
+
+// Unpack downloads the tarball and unpacks it into directory
+func (h *HTTP) Unpack(directory string) (string, error) {
+	logrus.Infof("Downloading build context from %s", h.url)
+	req, err := http.NewRequest("GET", h.url, nil)
+	if err != nil {
+		return "", err
+	}
+	body, err := download(h.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	return unpackTar(body, directory)
+}
+
+// download sends req with client, or the default client if it is nil, and returns the body of a successful response
+func download(client *http.Client, req *http.Request) (io.ReadCloser, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, errors.Wrap(err, "downloading build context")
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.Errorf("downloading build context from %s: %s", req.URL, resp.Status)
+	}
+	return resp.Body, nil
+}
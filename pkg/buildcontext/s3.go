@@ -0,0 +1,174 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildcontext
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const s3Prefix = "s3://"
+
+// emptyPayloadHash is the SHA256 of the empty body of GET requests
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// S3 is a build context tarball in a bucket of Amazon S3 or another S3-compatible store, such as MinIO
+type S3 struct {
+	bucket string
+	object string
+	// endpoint is the URL of the store, or "" for Amazon S3
+	endpoint string
+	region   string
+	creds    s3Credentials
+	// client is the client the tarball is downloaded with, or nil for the default client
+	client *http.Client
+}
+
+// s3Credentials are the AWS credentials requests are signed with. Requests aren't signed if AccessKeyID is empty.
+type s3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// newS3 returns the build context in the object s3://<bucket>/<object>, in the store at endpoint.
+// The region and credentials are read from the environment variables the AWS CLI uses.
+func newS3(srcContext, endpoint string) (*S3, error) {
+	bucket, object, err := splitBucketURL(srcContext, s3Prefix)
+	if err != nil {
+		return nil, err
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3{
+		bucket:   bucket,
+		object:   object,
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		region:   region,
+		creds: s3Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		},
+	}, nil
+}
+
+// url returns the URL of the object
+// Objects in other stores are addressed by path, which they all support, and objects in Amazon S3 by virtual host.
+func (s *S3) url() string {
+	if s.endpoint != "" {
+		return fmt.Sprintf("%s/%s/%s", s.endpoint, s.bucket, escapeS3Path(s.object))
+	}
+	return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", s.bucket, s.region, escapeS3Path(s.object))
+}
+
+// Unpack downloads the tarball from the bucket and unpacks it into directory
+func (s *S3) Unpack(directory string) (string, error) {
+	logrus.Infof("Downloading build context from S3 object s3://%s/%s", s.bucket, s.object)
+	req, err := http.NewRequest("GET", s.url(), nil)
+	if err != nil {
+		return "", err
+	}
+	if s.creds.AccessKeyID != "" {
+		signS3Request(req, s.creds, s.region, time.Now())
+	}
+	body, err := download(s.client, req)
+	if err != nil {
+		return "", err
+	}
+	defer body.Close()
+	return unpackTar(body, directory)
+}
+
+// signS3Request signs the bodiless request req with AWS Signature Version 4, signing the host and every header set on req
+func signS3Request(req *http.Request, creds s3Credentials, region string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", emptyPayloadHash)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders string
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		req.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		emptyPayloadHash,
+	}, "\n")
+
+	scope := amzDate[:8] + "/" + region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		creds.AccessKeyID, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// escapeS3Path escapes every byte of the object key p except unreserved characters and slashes,
+// as S3 expects in the canonical request
+func escapeS3Path(p string) string {
+	var escaped bytes.Buffer
+	for _, b := range []byte(p) {
+		if b >= 'A' && b <= 'Z' || b >= 'a' && b <= 'z' || b >= '0' && b <= '9' || strings.IndexByte("-_.~/", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
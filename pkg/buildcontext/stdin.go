@@ -0,0 +1,36 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package buildcontext
+
+import (
+	"io"
+
+	"github.com/sirupsen/logrus"
+)
+
+const stdinContext = "tar://stdin"
+
+// Stdin is a build context tarball read from standard input
+type Stdin struct {
+	r io.Reader
+}
+
+// Unpack reads the tarball and unpacks it into directory
+func (s *Stdin) Unpack(directory string) (string, error) {
+	logrus.Info("Reading build context from stdin")
+	return unpackTar(s.r, directory)
+}
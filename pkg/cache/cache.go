@@ -0,0 +1,159 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/image"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/containers/image/docker"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// LayerCache stores the layers created by commands in a registry repository
+// Each layer is pushed as an image containing only that layer and the config after the command ran,
+// tagged with the cache key of the command.
+type LayerCache struct {
+	repo      string
+	layersDir string
+	sys       *types.SystemContext
+}
+
+// CachedLayer is a layer retrieved from the cache
+type CachedLayer struct {
+	// Layer is the layer, or nil if the command didn't change any files
+	Layer *util.Layer
+	// Config is the image config after the command ran
+	Config *manifest.Schema2Config
+}
+
+// NewLayerCache returns a cache backed by repo, which is accessed with sys
+// Retrieved layers are written to layersDir.
+func NewLayerCache(repo, layersDir string, sys *types.SystemContext) *LayerCache {
+	return &LayerCache{
+		repo:      repo,
+		layersDir: layersDir,
+		sys:       sys,
+	}
+}
+
+// Key returns the cache key for a command, from the key of the previous command and information about this command
+func Key(prevKey string, parts ...string) string {
+	h := sha256.New()
+	h.Write([]byte(prevKey))
+	for _, part := range parts {
+		// Separate the parts so that moving text from one part to another changes the key
+		h.Write([]byte{0})
+		h.Write([]byte(part))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// RetrieveLayer returns the layer cached under key
+// An error is returned if there is no layer cached under key.
+func (c *LayerCache) RetrieveLayer(key string) (*CachedLayer, error) {
+	ref, err := c.reference(key)
+	if err != nil {
+		return nil, err
+	}
+	src, err := ref.NewImageSource(c.sys)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	b, _, err := src.GetManifest(nil)
+	if err != nil {
+		return nil, err
+	}
+	m, err := manifest.Schema2FromManifest(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.LayersDescriptors) > 1 {
+		return nil, errors.Errorf("cached image %s has %d layers, expected at most one", ref.DockerReference(), len(m.LayersDescriptors))
+	}
+
+	configBlob, err := getBlob(src, m.ConfigDescriptor)
+	if err != nil {
+		return nil, err
+	}
+	var cfg manifest.Schema2Image
+	if err := json.Unmarshal(configBlob, &cfg); err != nil {
+		return nil, err
+	}
+	cached := &CachedLayer{
+		Config: cfg.Config,
+	}
+	if len(m.LayersDescriptors) == 0 {
+		return cached, nil
+	}
+	if cfg.RootFS == nil || len(cfg.RootFS.DiffIDs) != 1 {
+		return nil, errors.Errorf("cached image %s has a config which doesn't match its layer", ref.DockerReference())
+	}
+	desc := m.LayersDescriptors[0]
+	rc, _, err := src.GetBlob(types.BlobInfo{Digest: desc.Digest, Size: desc.Size})
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	cached.Layer, err = util.WriteCompressedLayer(c.layersDir, rc, desc.Digest, cfg.RootFS.DiffIDs[0])
+	if err != nil {
+		return nil, err
+	}
+	return cached, nil
+}
+
+// PushLayer caches layer and the config after the command ran under key
+// layer should be nil if the command didn't change any files.
+func (c *LayerCache) PushLayer(key string, layer *util.Layer, config *manifest.Schema2Config) error {
+	ref, err := c.reference(key)
+	if err != nil {
+		return err
+	}
+	ms, err := image.NewSourceImage(nil)
+	if err != nil {
+		return err
+	}
+	cfg := *config
+	ms.SetConfig(&cfg, constants.Author, layer == nil)
+	if layer != nil {
+		ms.AppendLayer(layer)
+	}
+	logrus.Infof("Pushing layer to cache %s", ref.DockerReference())
+	return image.CopyImage(ms, ref, c.sys)
+}
+
+func (c *LayerCache) reference(key string) (types.ImageReference, error) {
+	return docker.ParseReference("//" + c.repo + ":" + key)
+}
+
+func getBlob(src types.ImageSource, desc manifest.Schema2Descriptor) ([]byte, error) {
+	rc, _, err := src.GetBlob(types.BlobInfo{Digest: desc.Digest, Size: desc.Size})
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,163 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cache
+
+import (
+	"archive/tar"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func newTestCache(registry *testutil.Registry, layersDir string) *LayerCache {
+	return NewLayerCache(registry.Host()+"/test/cache", layersDir, &types.SystemContext{
+		// The test registry is served over plain HTTP
+		DockerInsecureSkipTLSVerify: true,
+	})
+}
+
+func testLayer(t *testing.T, layersDir string) *util.Layer {
+	lw, err := util.NewLayerWriter(layersDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tar.NewWriter(lw)
+	content := []byte("hello")
+	if err := w.WriteHeader(&tar.Header{Name: "foo", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := lw.Layer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return layer
+}
+
+func TestPushAndRetrieveLayer(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	layersDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(layersDir)
+	c := newTestCache(registry, layersDir)
+
+	layer := testLayer(t, layersDir)
+	config := &manifest.Schema2Config{
+		Env:        []string{"PATH=/usr/bin"},
+		WorkingDir: "/app",
+	}
+	key := Key("base", "RUN echo hello")
+	if err := c.PushLayer(key, layer, config); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := c.RetrieveLayer(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, config, cached.Config)
+	// The retrieved layer is written to a new file
+	if cached.Layer.Path == layer.Path {
+		t.Error("Expected retrieved layer to be written to a new file")
+	}
+	retrieved := *cached.Layer
+	retrieved.Path = layer.Path
+	testutil.CheckErrorAndDeepEqual(t, false, nil, *layer, retrieved)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{key}, registry.Tags("test/cache"))
+}
+
+func TestPushAndRetrieveEmptyLayer(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	c := newTestCache(registry, os.TempDir())
+
+	config := &manifest.Schema2Config{
+		Env: []string{"PATH=/usr/bin"},
+	}
+	key := Key("base", "RUN true")
+	if err := c.PushLayer(key, nil, config); err != nil {
+		t.Fatal(err)
+	}
+	cached, err := c.RetrieveLayer(key)
+	testutil.CheckErrorAndDeepEqual(t, false, err, &CachedLayer{Config: config}, cached)
+}
+
+func TestRetrieveLayerMiss(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	c := newTestCache(registry, os.TempDir())
+
+	_, err := c.RetrieveLayer(Key("base", "RUN echo hello"))
+	testutil.CheckError(t, true, err)
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		description string
+		prevKey     string
+		parts       []string
+		otherKey    string
+		otherParts  []string
+		shouldEqual bool
+	}{
+		{
+			description: "same inputs",
+			prevKey:     "base",
+			parts:       []string{"RUN echo hello"},
+			otherKey:    "base",
+			otherParts:  []string{"RUN echo hello"},
+			shouldEqual: true,
+		},
+		{
+			description: "different previous key",
+			prevKey:     "base",
+			parts:       []string{"RUN echo hello"},
+			otherKey:    "other",
+			otherParts:  []string{"RUN echo hello"},
+		},
+		{
+			description: "different command",
+			prevKey:     "base",
+			parts:       []string{"RUN echo hello"},
+			otherKey:    "base",
+			otherParts:  []string{"RUN echo goodbye"},
+		},
+		{
+			description: "text moved between parts",
+			prevKey:     "base",
+			parts:       []string{"ab", "c"},
+			otherKey:    "base",
+			otherParts:  []string{"a", "bc"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			equal := Key(test.prevKey, test.parts...) == Key(test.otherKey, test.otherParts...)
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.shouldEqual, equal)
+		})
+	}
+}
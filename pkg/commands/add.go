@@ -17,10 +17,12 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
 	"github.com/sirupsen/logrus"
+	"net/url"
 	"path/filepath"
 	"strings"
 )
@@ -39,7 +41,7 @@ type AddCommand struct {
 // 		- If dest doesn't end with a slash, the filepath is inferred to be <dest>/<filename>
 // 	2. If <src> is a local tar archive:
 // 		-If <src> is a local tar archive, it is unpacked at the dest, as 'tar -x' would
-func (a *AddCommand) ExecuteCommand(config *manifest.Schema2Config) error {
+func (a *AddCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
 	srcs := a.cmd.SourcesAndDest[:len(a.cmd.SourcesAndDest)-1]
 	dest := a.cmd.SourcesAndDest[len(a.cmd.SourcesAndDest)-1]
 
@@ -47,11 +49,16 @@ func (a *AddCommand) ExecuteCommand(config *manifest.Schema2Config) error {
 	logrus.Infof("dest: %s", dest)
 
 	// First, resolve any environment replacement
-	resolvedEnvs, err := util.ResolveEnvironmentReplacementList(a.cmd.SourcesAndDest, config.Env, true)
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	resolvedEnvs, err := util.ResolveEnvironmentReplacementList(a.cmd.SourcesAndDest, replacementEnvs, true)
 	if err != nil {
 		return err
 	}
 	dest = resolvedEnvs[len(resolvedEnvs)-1]
+	uid, gid, err := resolveChown(a.cmd.Chown, replacementEnvs)
+	if err != nil {
+		return err
+	}
 	// Get a map of [src]:[files rooted at src]
 	srcMap, err := util.ResolveSources(resolvedEnvs, a.buildcontext)
 	if err != nil {
@@ -77,6 +84,9 @@ func (a *AddCommand) ExecuteCommand(config *manifest.Schema2Config) error {
 				if err := util.DownloadFileToDest(file, urlDest); err != nil {
 					return err
 				}
+				if err := chownFiles([]string{urlDest}, uid, gid); err != nil {
+					return err
+				}
 				a.snapshotFiles = append(a.snapshotFiles, urlDest)
 				delete(srcMap, src)
 			} else if isFilenameSource && util.IsFileLocalTarArchive(filePath) {
@@ -84,6 +94,15 @@ func (a *AddCommand) ExecuteCommand(config *manifest.Schema2Config) error {
 				if err := util.UnpackLocalTarArchive(filePath, dest); err != nil {
 					return err
 				}
+				if uid != -1 || gid != -1 {
+					unpackedFiles, err := util.LocalTarArchiveFiles(filePath, dest)
+					if err != nil {
+						return err
+					}
+					if err := chownFiles(unpackedFiles, uid, gid); err != nil {
+						return err
+					}
+				}
 				// Add the unpacked files to the snapshotter
 				filesAdded, err := util.Files(dest)
 				if err != nil {
@@ -106,16 +125,33 @@ func (a *AddCommand) ExecuteCommand(config *manifest.Schema2Config) error {
 	copyCmd := CopyCommand{
 		cmd: &instructions.CopyCommand{
 			SourcesAndDest: append(regularSrcs, dest),
+			Chown:          a.cmd.Chown,
 		},
 		buildcontext: a.buildcontext,
 	}
-	if err := copyCmd.ExecuteCommand(config); err != nil {
+	if err := copyCmd.ExecuteCommand(config, buildArgs); err != nil {
 		return err
 	}
 	a.snapshotFiles = append(a.snapshotFiles, copyCmd.snapshotFiles...)
 	return nil
 }
 
+// UsesRemoteURLs returns true if any of the sources of the command is a remote URL, whose contents can change
+// without the Dockerfile or the build context changing, or if the sources can't be resolved
+func (a *AddCommand) UsesRemoteURLs(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) bool {
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	resolvedEnvs, err := util.ResolveEnvironmentReplacementList(a.cmd.SourcesAndDest, replacementEnvs, true)
+	if err != nil {
+		return true
+	}
+	for _, src := range resolvedEnvs[:len(resolvedEnvs)-1] {
+		if u, err := url.Parse(src); err == nil && (u.Scheme == "http" || u.Scheme == "https") {
+			return true
+		}
+	}
+	return false
+}
+
 func isFilenameSource(srcMap map[string][]string, fileName string) (bool, error) {
 	for src := range srcMap {
 		matched, err := filepath.Match(src, fileName)
@@ -129,6 +165,12 @@ func isFilenameSource(srcMap map[string][]string, fileName string) (bool, error)
 	return false, nil
 }
 
+// FilesUsedFromContext returns the paths of the local files which will be added
+// Remote file URLs aren't included, since their contents are only known once they're downloaded.
+func (a *AddCommand) FilesUsedFromContext(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) ([]string, error) {
+	return filesUsedFromContext(a.cmd.SourcesAndDest, a.buildcontext, config, buildArgs)
+}
+
 // FilesToSnapshot should return an empty array if still nil; no files were changed
 func (a *AddCommand) FilesToSnapshot() []string {
 	return a.snapshotFiles
@@ -136,5 +178,5 @@ func (a *AddCommand) FilesToSnapshot() []string {
 
 // CreatedBy returns some information about the command for the image config
 func (a *AddCommand) CreatedBy() string {
-	return strings.Join(a.cmd.SourcesAndDest, " ")
+	return strings.Join(append(chownFlag(a.cmd.Chown), a.cmd.SourcesAndDest...), " ")
 }
@@ -0,0 +1,61 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"github.com/sirupsen/logrus"
+)
+
+type ArgCommand struct {
+	cmd *instructions.ArgCommand
+}
+
+// ExecuteCommand declares the ARG for the rest of the stage
+// The value is only available while building, so it isn't added to the config
+func (r *ArgCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
+	logrus.Info("cmd: ARG")
+	value := r.cmd.Value
+	if value != nil {
+		resolvedValue, err := util.ResolveEnvironmentReplacement(*value, buildArgs.ReplacementEnvs(config.Env), false)
+		if err != nil {
+			return err
+		}
+		value = &resolvedValue
+	}
+	buildArgs.AddArg(r.cmd.Key, value)
+	return nil
+}
+
+// FilesToSnapshot returns an empty array since this command only touches metadata
+func (r *ArgCommand) FilesToSnapshot() []string {
+	return []string{}
+}
+
+// CreatedBy returns some information about the command for the image config history
+func (r *ArgCommand) CreatedBy() string {
+	arg := r.cmd.Key
+	if r.cmd.Value != nil {
+		arg = arg + "=" + *r.cmd.Value
+	}
+	return strings.Join([]string{r.cmd.Name(), arg}, " ")
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package commands
+
+import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"testing"
+)
+
+func stringPtr(s string) *string {
+	return &s
+}
+
+var argTests = []struct {
+	key          string
+	value        *string
+	flagArgs     []string
+	expectedEnvs []string
+}{
+	{
+		key:          "foo",
+		value:        stringPtr("bar"),
+		expectedEnvs: []string{"foo=bar", "env=value"},
+	},
+	{
+		key:          "foo",
+		value:        stringPtr("bar"),
+		flagArgs:     []string{"foo=flag"},
+		expectedEnvs: []string{"foo=flag", "env=value"},
+	},
+	{
+		key:          "foo",
+		value:        nil,
+		expectedEnvs: []string{"env=value"},
+	},
+	{
+		key:          "foo",
+		value:        stringPtr("$env/bar"),
+		expectedEnvs: []string{"foo=value/bar", "env=value"},
+	},
+	{
+		key:          "env",
+		value:        stringPtr("arg"),
+		expectedEnvs: []string{"env=value"},
+	},
+}
+
+func TestArgExecute(t *testing.T) {
+	for _, test := range argTests {
+		cfg := &manifest.Schema2Config{
+			Env: []string{
+				"env=value",
+			},
+		}
+		buildArgs := dockerfile.NewBuildArgs(test.flagArgs)
+		cmd := ArgCommand{
+			&instructions.ArgCommand{
+				Key:   test.key,
+				Value: test.value,
+			},
+		}
+		err := cmd.ExecuteCommand(cfg, buildArgs)
+		testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedEnvs, buildArgs.ReplacementEnvs(cfg.Env))
+		// ARGs should never be persisted in the config
+		testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"env=value"}, cfg.Env)
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
 	"github.com/sirupsen/logrus"
@@ -24,19 +25,18 @@ import (
 )
 
 type CmdCommand struct {
-	cmd *instructions.CmdCommand
+	cmd   *instructions.CmdCommand
+	shell []string
 }
 
 // ExecuteCommand executes the CMD command
 // Argument handling is the same as RUN.
-func (c *CmdCommand) ExecuteCommand(config *manifest.Schema2Config) error {
+func (c *CmdCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
 	logrus.Info("cmd: CMD")
 	var newCommand []string
 	if c.cmd.PrependShell {
-		// This is the default shell on Linux
-		// TODO: Support shell command here
-		shell := []string{"/bin/sh", "-c"}
-		newCommand = append(shell, strings.Join(c.cmd.CmdLine, " "))
+		c.shell = getShell(config)
+		newCommand = append(shellOrDefault(c.shell), strings.Join(c.cmd.CmdLine, " "))
 	} else {
 		newCommand = c.cmd.CmdLine
 	}
@@ -56,8 +56,7 @@ func (c *CmdCommand) CreatedBy() string {
 	cmd := []string{"CMD"}
 	cmdLine := strings.Join(c.cmd.CmdLine, " ")
 	if c.cmd.PrependShell {
-		// TODO: Support shell command here
-		shell := []string{"/bin/sh", "-c"}
+		shell := shellOrDefault(c.shell)
 		appendedShell := append(cmd, shell...)
 		return strings.Join(append(appendedShell, cmdLine), " ")
 	}
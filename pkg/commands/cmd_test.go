@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/GoogleCloudPlatform/kaniko/testutil"
 	"github.com/containers/image/manifest"
 	"github.com/containers/image/pkg/strslice"
@@ -25,6 +26,7 @@ import (
 
 var cmdTests = []struct {
 	prependShell bool
+	shell        strslice.StrSlice
 	cmdLine      []string
 	expectedCmd  strslice.StrSlice
 }{
@@ -38,6 +40,12 @@ var cmdTests = []struct {
 		cmdLine:      []string{"echo", "cmd2"},
 		expectedCmd:  strslice.StrSlice{"echo", "cmd2"},
 	},
+	{
+		prependShell: true,
+		shell:        strslice.StrSlice{"/bin/bash", "-c"},
+		cmdLine:      []string{"echo", "cmd3"},
+		expectedCmd:  strslice.StrSlice{"/bin/bash", "-c", "echo cmd3"},
+	},
 }
 
 func TestExecuteCmd(t *testing.T) {
@@ -47,15 +55,16 @@ func TestExecuteCmd(t *testing.T) {
 	}
 
 	for _, test := range cmdTests {
+		cfg.Shell = test.shell
 		cmd := CmdCommand{
-			&instructions.CmdCommand{
+			cmd: &instructions.CmdCommand{
 				ShellDependantCmdLine: instructions.ShellDependantCmdLine{
 					PrependShell: test.prependShell,
 					CmdLine:      test.cmdLine,
 				},
 			},
 		}
-		err := cmd.ExecuteCommand(cfg)
+		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedCmd, cfg.Cmd)
 	}
 }
@@ -17,6 +17,11 @@ limitations under the License.
 package commands
 
 import (
+	"path/filepath"
+	"sort"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
 	"github.com/pkg/errors"
@@ -26,19 +31,30 @@ type DockerCommand interface {
 	// ExecuteCommand is responsible for:
 	// 	1. Making required changes to the filesystem (ex. copying files for ADD/COPY or setting ENV variables)
 	//  2. Updating metadata fields in the config
+	// ARGs in scope are available through the build args, but shouldn't be persisted in the config.
 	// It should not change the config history.
-	ExecuteCommand(*manifest.Schema2Config) error
+	ExecuteCommand(*manifest.Schema2Config, *dockerfile.BuildArgs) error
 	// The config history has a "created by" field, should return information about the command
 	CreatedBy() string
 	// A list of files to snapshot, empty for metadata commands or nil if we don't know
 	FilesToSnapshot() []string
 }
 
+// ContextCommand is implemented by commands which use files from the build context, like ADD and COPY
+type ContextCommand interface {
+	// FilesUsedFromContext returns the paths of the files the command will use, before it is executed
+	FilesUsedFromContext(*manifest.Schema2Config, *dockerfile.BuildArgs) ([]string, error)
+}
+
 func GetCommand(cmd instructions.Command, buildcontext string) (DockerCommand, error) {
 	switch c := cmd.(type) {
 	case *instructions.RunCommand:
 		return &RunCommand{cmd: c}, nil
 	case *instructions.CopyCommand:
+		// If copying from a previous stage, the sources are in that stage's file system instead of the build context
+		if c.From != "" {
+			buildcontext = util.StageDependencyDir(c.From)
+		}
 		return &CopyCommand{cmd: c, buildcontext: buildcontext}, nil
 	case *instructions.ExposeCommand:
 		return &ExposeCommand{cmd: c}, nil
@@ -60,6 +76,38 @@ func GetCommand(cmd instructions.Command, buildcontext string) (DockerCommand, e
 		return &OnBuildCommand{cmd: c}, nil
 	case *instructions.VolumeCommand:
 		return &VolumeCommand{cmd: c}, nil
+	case *instructions.ArgCommand:
+		return &ArgCommand{cmd: c}, nil
+	case *instructions.ShellCommand:
+		return &ShellCommand{cmd: c}, nil
+	case *instructions.HealthCheckCommand:
+		return &HealthCheckCommand{cmd: c}, nil
+	case *instructions.StopSignalCommand:
+		return &StopSignalCommand{cmd: c}, nil
 	}
 	return nil, errors.Errorf("%s is not a supported command", cmd.Name())
 }
+
+// filesUsedFromContext resolves the sources of an ADD or COPY command to the sorted paths of the files within buildcontext
+func filesUsedFromContext(srcsAndDest instructions.SourcesAndDest, buildcontext string, config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) ([]string, error) {
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	resolvedEnvs, err := util.ResolveEnvironmentReplacementList(srcsAndDest, replacementEnvs, true)
+	if err != nil {
+		return nil, err
+	}
+	srcMap, err := util.ResolveSources(resolvedEnvs, buildcontext)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, srcFiles := range srcMap {
+		for _, file := range srcFiles {
+			if util.IsSrcRemoteFileURL(file) {
+				continue
+			}
+			files = append(files, filepath.Join(buildcontext, file))
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
@@ -17,6 +17,8 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
@@ -32,7 +34,7 @@ type CopyCommand struct {
 	snapshotFiles []string
 }
 
-func (c *CopyCommand) ExecuteCommand(config *manifest.Schema2Config) error {
+func (c *CopyCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
 	srcs := c.cmd.SourcesAndDest[:len(c.cmd.SourcesAndDest)-1]
 	dest := c.cmd.SourcesAndDest[len(c.cmd.SourcesAndDest)-1]
 
@@ -40,11 +42,16 @@ func (c *CopyCommand) ExecuteCommand(config *manifest.Schema2Config) error {
 	logrus.Infof("dest: %s", dest)
 
 	// First, resolve any environment replacement
-	resolvedEnvs, err := util.ResolveEnvironmentReplacementList(c.cmd.SourcesAndDest, config.Env, true)
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	resolvedEnvs, err := util.ResolveEnvironmentReplacementList(c.cmd.SourcesAndDest, replacementEnvs, true)
 	if err != nil {
 		return err
 	}
 	dest = resolvedEnvs[len(resolvedEnvs)-1]
+	uid, gid, err := resolveChown(c.cmd.Chown, replacementEnvs)
+	if err != nil {
+		return err
+	}
 	// Get a map of [src]:[files rooted at src]
 	srcMap, err := util.ResolveSources(resolvedEnvs, c.buildcontext)
 	if err != nil {
@@ -90,6 +97,9 @@ func (c *CopyCommand) ExecuteCommand(config *manifest.Schema2Config) error {
 					return err
 				}
 			}
+			if err := chownFiles([]string{destPath}, uid, gid); err != nil {
+				return err
+			}
 			// Append the destination file to the list of files that should be snapshotted later
 			c.snapshotFiles = append(c.snapshotFiles, destPath)
 		}
@@ -97,6 +107,11 @@ func (c *CopyCommand) ExecuteCommand(config *manifest.Schema2Config) error {
 	return nil
 }
 
+// FilesUsedFromContext returns the paths of the files which will be copied
+func (c *CopyCommand) FilesUsedFromContext(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) ([]string, error) {
+	return filesUsedFromContext(c.cmd.SourcesAndDest, c.buildcontext, config, buildArgs)
+}
+
 // FilesToSnapshot should return an empty array if still nil; no files were changed
 func (c *CopyCommand) FilesToSnapshot() []string {
 	return c.snapshotFiles
@@ -104,5 +119,44 @@ func (c *CopyCommand) FilesToSnapshot() []string {
 
 // CreatedBy returns some information about the command for the image config
 func (c *CopyCommand) CreatedBy() string {
-	return strings.Join(c.cmd.SourcesAndDest, " ")
+	return strings.Join(append(chownFlag(c.cmd.Chown), c.cmd.SourcesAndDest...), " ")
+}
+
+// resolveChown resolves the user and group given to --chown to a uid and gid, which are -1 if --chown wasn't set
+// Names are looked up in the image being built.
+func resolveChown(chown string, replacementEnvs []string) (int, int, error) {
+	if chown == "" {
+		return -1, -1, nil
+	}
+	resolved, err := util.ResolveEnvironmentReplacement(chown, replacementEnvs, false)
+	if err != nil {
+		return -1, -1, err
+	}
+	uid, gid, err := util.GetUIDAndGID(resolved, constants.RootDir)
+	if err != nil {
+		return -1, -1, err
+	}
+	logrus.Infof("Setting owner of files to %d:%d", uid, gid)
+	return uid, gid, nil
+}
+
+// chownFiles changes the owner of files to uid and gid, unless they're -1
+func chownFiles(files []string, uid, gid int) error {
+	if uid == -1 && gid == -1 {
+		return nil
+	}
+	for _, file := range files {
+		if err := os.Lchown(file, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chownFlag returns the --chown flag of an ADD or COPY command for its config history, if it was set
+func chownFlag(chown string) []string {
+	if chown == "" {
+		return nil
+	}
+	return []string{"--chown=" + chown}
 }
@@ -0,0 +1,103 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package commands
+
+import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+var copyChownTests = []struct {
+	chown       string
+	env         []string
+	expectedUID uint32
+	expectedGID uint32
+}{
+	{
+		chown:       "1234:5678",
+		expectedUID: 1234,
+		expectedGID: 5678,
+	},
+	{
+		chown:       "1234",
+		expectedUID: 1234,
+		expectedGID: 1234,
+	},
+	{
+		chown:       "$UID:$GID",
+		env:         []string{"UID=4321", "GID=8765"},
+		expectedUID: 4321,
+		expectedGID: 8765,
+	},
+}
+
+func TestCopyChown(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("changing the owner of files requires root")
+	}
+	for _, test := range copyChownTests {
+		buildcontext, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(buildcontext)
+		dest, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dest)
+		if err := testutil.SetupFiles(buildcontext, map[string]string{"dir/file": "hello"}); err != nil {
+			t.Fatal(err)
+		}
+
+		cmd := CopyCommand{
+			cmd: &instructions.CopyCommand{
+				SourcesAndDest: []string{"dir", dest + "/"},
+				Chown:          test.chown,
+			},
+			buildcontext: buildcontext,
+		}
+		cfg := &manifest.Schema2Config{
+			Env: test.env,
+		}
+		if err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{})); err != nil {
+			t.Fatal(err)
+		}
+		fi, err := os.Lstat(filepath.Join(dest, "file"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		stat := fi.Sys().(*syscall.Stat_t)
+		testutil.CheckErrorAndDeepEqual(t, false, nil, []uint32{test.expectedUID, test.expectedGID}, []uint32{stat.Uid, stat.Gid})
+	}
+}
+
+func TestCopyCreatedBy(t *testing.T) {
+	cmd := CopyCommand{
+		cmd: &instructions.CopyCommand{
+			SourcesAndDest: []string{"foo", "/bar"},
+			Chown:          "app:app",
+		},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, "--chown=app:app foo /bar", cmd.CreatedBy())
+}
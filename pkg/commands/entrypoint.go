@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
 	"github.com/sirupsen/logrus"
@@ -24,18 +25,17 @@ import (
 )
 
 type EntrypointCommand struct {
-	cmd *instructions.EntrypointCommand
+	cmd   *instructions.EntrypointCommand
+	shell []string
 }
 
 // ExecuteCommand handles command processing similar to CMD and RUN,
-func (e *EntrypointCommand) ExecuteCommand(config *manifest.Schema2Config) error {
+func (e *EntrypointCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
 	logrus.Info("cmd: ENTRYPOINT")
 	var newCommand []string
 	if e.cmd.PrependShell {
-		// This is the default shell on Linux
-		// TODO: Support shell command here
-		shell := []string{"/bin/sh", "-c"}
-		newCommand = append(shell, strings.Join(e.cmd.CmdLine, " "))
+		e.shell = getShell(config)
+		newCommand = append(shellOrDefault(e.shell), strings.Join(e.cmd.CmdLine, " "))
 	} else {
 		newCommand = e.cmd.CmdLine
 	}
@@ -55,8 +55,7 @@ func (e *EntrypointCommand) CreatedBy() string {
 	entrypoint := []string{"ENTRYPOINT"}
 	cmdLine := strings.Join(e.cmd.CmdLine, " ")
 	if e.cmd.PrependShell {
-		// TODO: Support shell command here
-		shell := []string{"/bin/sh", "-c"}
+		shell := shellOrDefault(e.shell)
 		appendedShell := append(entrypoint, shell...)
 		return strings.Join(append(appendedShell, cmdLine), " ")
 	}
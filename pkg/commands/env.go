@@ -19,6 +19,7 @@ package commands
 import (
 	"strings"
 
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
@@ -29,15 +30,16 @@ type EnvCommand struct {
 	cmd *instructions.EnvCommand
 }
 
-func (e *EnvCommand) ExecuteCommand(config *manifest.Schema2Config) error {
+func (e *EnvCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
 	logrus.Info("cmd: ENV")
 	newEnvs := e.cmd.Env
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
 	for index, pair := range newEnvs {
-		expandedKey, err := util.ResolveEnvironmentReplacement(pair.Key, config.Env, false)
+		expandedKey, err := util.ResolveEnvironmentReplacement(pair.Key, replacementEnvs, false)
 		if err != nil {
 			return err
 		}
-		expandedValue, err := util.ResolveEnvironmentReplacement(pair.Value, config.Env, false)
+		expandedValue, err := util.ResolveEnvironmentReplacement(pair.Value, replacementEnvs, false)
 		if err != nil {
 			return err
 		}
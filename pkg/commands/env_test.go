@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/GoogleCloudPlatform/kaniko/testutil"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
@@ -86,6 +87,6 @@ func Test_EnvExecute(t *testing.T) {
 		"HOME=/root",
 		"/usr/=/root/",
 	}
-	err := envCmd.ExecuteCommand(cfg)
+	err := envCmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
 	testutil.CheckErrorAndDeepEqual(t, false, err, expectedEnvs, cfg.Env)
 }
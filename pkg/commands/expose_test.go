@@ -17,6 +17,7 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/GoogleCloudPlatform/kaniko/testutil"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
@@ -60,7 +61,7 @@ func TestUpdateExposedPorts(t *testing.T) {
 		"8085/udp": {},
 	}
 
-	err := exposeCmd.ExecuteCommand(cfg)
+	err := exposeCmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
 	testutil.CheckErrorAndDeepEqual(t, false, err, expectedPorts, cfg.ExposedPorts)
 }
 
@@ -79,6 +80,6 @@ func TestInvalidProtocol(t *testing.T) {
 		},
 	}
 
-	err := exposeCmd.ExecuteCommand(cfg)
+	err := exposeCmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
 	testutil.CheckErrorAndDeepEqual(t, true, err, nil, nil)
 }
@@ -0,0 +1,55 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"github.com/sirupsen/logrus"
+)
+
+type HealthCheckCommand struct {
+	cmd *instructions.HealthCheckCommand
+}
+
+// ExecuteCommand sets the healthcheck in the config
+// HEALTHCHECK NONE is stored as a test of ["NONE"], which disables any healthcheck inherited from the base image
+func (h *HealthCheckCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
+	logrus.Info("cmd: HEALTHCHECK")
+	health := h.cmd.Health
+	logrus.Infof("Replacing Healthcheck in config with %v", health.Test)
+	config.Healthcheck = &manifest.Schema2HealthConfig{
+		Test:     append([]string{}, health.Test...),
+		Interval: health.Interval,
+		Timeout:  health.Timeout,
+		Retries:  health.Retries,
+	}
+	return nil
+}
+
+// FilesToSnapshot returns an empty array since this is a metadata command
+func (h *HealthCheckCommand) FilesToSnapshot() []string {
+	return []string{}
+}
+
+// CreatedBy returns some information about the command for the image config history
+func (h *HealthCheckCommand) CreatedBy() string {
+	return strings.Join(append([]string{h.cmd.Name()}, h.cmd.Health.Test...), " ")
+}
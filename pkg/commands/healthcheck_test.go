@@ -0,0 +1,79 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package commands
+
+import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"testing"
+	"time"
+)
+
+var healthCheckTests = []struct {
+	health         *container.HealthConfig
+	expectedHealth *manifest.Schema2HealthConfig
+}{
+	{
+		health: &container.HealthConfig{
+			Test:     []string{"CMD-SHELL", "curl -f http://localhost/"},
+			Interval: 5 * time.Second,
+			Timeout:  3 * time.Second,
+			Retries:  2,
+		},
+		expectedHealth: &manifest.Schema2HealthConfig{
+			Test:     []string{"CMD-SHELL", "curl -f http://localhost/"},
+			Interval: 5 * time.Second,
+			Timeout:  3 * time.Second,
+			Retries:  2,
+		},
+	},
+	{
+		health: &container.HealthConfig{
+			Test: []string{"CMD", "/healthcheck"},
+		},
+		expectedHealth: &manifest.Schema2HealthConfig{
+			Test: []string{"CMD", "/healthcheck"},
+		},
+	},
+	{
+		health: &container.HealthConfig{
+			Test: []string{"NONE"},
+		},
+		expectedHealth: &manifest.Schema2HealthConfig{
+			Test: []string{"NONE"},
+		},
+	},
+}
+
+func TestExecuteHealthCheck(t *testing.T) {
+	for _, test := range healthCheckTests {
+		cfg := &manifest.Schema2Config{
+			Healthcheck: &manifest.Schema2HealthConfig{
+				Test: []string{"CMD", "/base-healthcheck"},
+			},
+		}
+		cmd := HealthCheckCommand{
+			&instructions.HealthCheckCommand{
+				Health: test.health,
+			},
+		}
+		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedHealth, cfg.Healthcheck)
+	}
+}
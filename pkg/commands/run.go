@@ -17,28 +17,42 @@ limitations under the License.
 package commands
 
 import (
+	"io"
 	"os"
 	"os/exec"
-	"strconv"
 	"strings"
 	"syscall"
 
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
+	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 )
 
+// maxStderrTail is how much of the end of the standard error of a failed command is kept for its error
+const maxStderrTail = 4096
+
 type RunCommand struct {
-	cmd *instructions.RunCommand
+	cmd   *instructions.RunCommand
+	shell []string
+	// trace is set if the command should run under ptrace, in which case files are the files it changed
+	trace bool
+	files []string
+}
+
+// EnableTracing makes the command run under ptrace, so that only the files it changes need to be snapshotted
+func (r *RunCommand) EnableTracing() {
+	r.trace = true
 }
 
-func (r *RunCommand) ExecuteCommand(config *manifest.Schema2Config) error {
+func (r *RunCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
 	var newCommand []string
 	if r.cmd.PrependShell {
-		// This is the default shell on Linux
-		// TODO: Support shell command here
-		shell := []string{"/bin/sh", "-c"}
-		newCommand = append(shell, strings.Join(r.cmd.CmdLine, " "))
+		r.shell = getShell(config)
+		newCommand = append(shellOrDefault(r.shell), strings.Join(r.cmd.CmdLine, " "))
 	} else {
 		newCommand = r.cmd.CmdLine
 	}
@@ -49,43 +63,86 @@ func (r *RunCommand) ExecuteCommand(config *manifest.Schema2Config) error {
 	cmd := exec.Command(newCommand[0], newCommand[1:]...)
 	cmd.Dir = config.WorkingDir
 	cmd.Stdout = os.Stdout
-	cmd.Env = config.Env
+	// Standard error is kept, so that the error returned if the command fails says why
+	stderr := &stderrTail{}
+	cmd.Stderr = io.MultiWriter(os.Stderr, stderr)
+	// ARGs in scope are available to the command, but ENV variables take precedence
+	cmd.Env = buildArgs.ReplacementEnvs(config.Env)
 
-	// If specified, run the command as a specific user
+	// Commands run as root unless USER is set. The user is looked up in the image now, since it may have been created
+	// by an earlier command, and HOME is set to its home directory unless ENV sets it, as with docker build.
+	userAndGroup := config.User
+	if userAndGroup == "" {
+		userAndGroup = "0"
+	}
+	execUser, err := util.LookupExecUser(userAndGroup, constants.RootDir)
+	if err != nil {
+		return errors.Wrapf(err, "looking up user %s", userAndGroup)
+	}
 	if config.User != "" {
-		userAndGroup := strings.Split(config.User, ":")
-		// uid and gid need to be uint32
-		uid64, err := strconv.ParseUint(userAndGroup[0], 10, 32)
-		if err != nil {
-			return err
-		}
-		uid := uint32(uid64)
-		var gid uint32
-		if len(userAndGroup) > 1 {
-			gid64, err := strconv.ParseUint(userAndGroup[1], 10, 32)
-			if err != nil {
-				return err
-			}
-			gid = uint32(gid64)
+		groups := make([]uint32, len(execUser.Groups))
+		for i, gid := range execUser.Groups {
+			groups[i] = uint32(gid)
 		}
 		cmd.SysProcAttr = &syscall.SysProcAttr{}
-		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(execUser.UID), Gid: uint32(execUser.GID), Groups: groups}
 	}
-	return cmd.Run()
+	if !hasEnv(cmd.Env, "HOME") {
+		cmd.Env = append(cmd.Env, "HOME="+execUser.Home)
+	}
+	if r.trace {
+		r.files, err = util.RunTraced(cmd)
+	} else {
+		err = cmd.Run()
+	}
+	if err != nil && stderr.Len() > 0 {
+		return errors.Errorf("%s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
+
+// hasEnv returns true if the variable key is set in env
+func hasEnv(env []string, key string) bool {
+	for _, e := range env {
+		if strings.HasPrefix(e, key+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// stderrTail keeps the last maxStderrTail bytes written to it
+type stderrTail struct {
+	b []byte
+}
+
+func (s *stderrTail) Write(p []byte) (int, error) {
+	s.b = append(s.b, p...)
+	if len(s.b) > maxStderrTail {
+		s.b = s.b[len(s.b)-maxStderrTail:]
+	}
+	return len(p), nil
+}
+
+func (s *stderrTail) Len() int {
+	return len(s.b)
+}
+
+func (s *stderrTail) String() string {
+	return string(s.b)
 }
 
-// FilesToSnapshot returns nil for this command because we don't know which files
-// have changed, so we snapshot the entire system.
+// FilesToSnapshot returns the files the command changed if it was traced
+// Otherwise it returns nil, because we don't know which files have changed, so we snapshot the entire system.
 func (r *RunCommand) FilesToSnapshot() []string {
-	return nil
+	return r.files
 }
 
 // Author returns some information about the command for the image config
 func (r *RunCommand) CreatedBy() string {
 	cmdLine := strings.Join(r.cmd.CmdLine, " ")
 	if r.cmd.PrependShell {
-		// TODO: Support shell command here
-		shell := []string{"/bin/sh", "-c"}
+		shell := shellOrDefault(r.shell)
 		return strings.Join(append(shell, cmdLine), " ")
 	}
 	return cmdLine
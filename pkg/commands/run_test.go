@@ -0,0 +1,85 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+var runTests = []struct {
+	cmdLine     string
+	expectedErr string
+}{
+	{
+		cmdLine: "echo hi >&2",
+	},
+	{
+		cmdLine:     "exit 3",
+		expectedErr: "exit status 3",
+	},
+	{
+		cmdLine:     "echo hi >&2; echo make: no rule to make target >&2; exit 2",
+		expectedErr: "exit status 2: hi\nmake: no rule to make target",
+	},
+	{
+		// Only the end of long output is kept
+		cmdLine:     "head -c 10000 /dev/zero | tr '\\0' x >&2; printf end >&2; false",
+		expectedErr: "exit status 1: " + strings.Repeat("x", maxStderrTail-len("end")) + "end",
+	},
+}
+
+func TestRunStderr(t *testing.T) {
+	for _, test := range runTests {
+		t.Run(test.cmdLine, func(t *testing.T) {
+			checkRunStderr(t, test.cmdLine, test.expectedErr, false)
+		})
+	}
+}
+
+func TestRunStderrTraced(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("tracing is only supported on linux/amd64")
+	}
+	for _, test := range runTests {
+		t.Run(test.cmdLine, func(t *testing.T) {
+			checkRunStderr(t, test.cmdLine, test.expectedErr, true)
+		})
+	}
+}
+
+func checkRunStderr(t *testing.T, cmdLine, expectedErr string, trace bool) {
+	cmd := RunCommand{
+		cmd: &instructions.RunCommand{
+			ShellDependantCmdLine: instructions.ShellDependantCmdLine{CmdLine: []string{cmdLine}, PrependShell: true},
+		},
+	}
+	if trace {
+		cmd.EnableTracing()
+	}
+	err := cmd.ExecuteCommand(&manifest.Schema2Config{}, dockerfile.NewBuildArgs(nil))
+	var actual string
+	if err != nil {
+		actual = err.Error()
+	}
+	testutil.CheckErrorAndDeepEqual(t, expectedErr != "", err, expectedErr, actual)
+}
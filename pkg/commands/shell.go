@@ -0,0 +1,74 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/strslice"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultShell is the default shell on Linux
+var defaultShell = []string{"/bin/sh", "-c"}
+
+type ShellCommand struct {
+	cmd *instructions.ShellCommand
+}
+
+// ExecuteCommand sets the shell used by the shell form of subsequent RUN, CMD and ENTRYPOINT commands
+// The shell is saved in the config, so it is also inherited by images built from this one
+func (s *ShellCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
+	logrus.Info("cmd: SHELL")
+	if len(s.cmd.Shell) == 0 {
+		return errors.New("SHELL requires at least one argument")
+	}
+	logrus.Infof("Replacing Shell in config with %v", s.cmd.Shell)
+	config.Shell = strslice.StrSlice(s.cmd.Shell)
+	return nil
+}
+
+// FilesToSnapshot returns an empty array since this is a metadata command
+func (s *ShellCommand) FilesToSnapshot() []string {
+	return []string{}
+}
+
+// CreatedBy returns some information about the command for the image config history
+func (s *ShellCommand) CreatedBy() string {
+	return strings.Join(append([]string{s.cmd.Name()}, s.cmd.Shell...), " ")
+}
+
+// getShell returns the shell the shell form of RUN, CMD and ENTRYPOINT should use,
+// which is the shell set by SHELL if there is one, or the default shell otherwise
+func getShell(config *manifest.Schema2Config) []string {
+	if len(config.Shell) > 0 {
+		return append([]string{}, config.Shell...)
+	}
+	return append([]string{}, defaultShell...)
+}
+
+// shellOrDefault returns shell, or the default shell if shell hasn't been set yet
+func shellOrDefault(shell []string) []string {
+	if shell == nil {
+		return append([]string{}, defaultShell...)
+	}
+	return append([]string{}, shell...)
+}
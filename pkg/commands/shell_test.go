@@ -0,0 +1,79 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package commands
+
+import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/pkg/strslice"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"testing"
+)
+
+var shellTests = []struct {
+	shell         []string
+	expectedShell strslice.StrSlice
+	shouldErr     bool
+}{
+	{
+		shell:         []string{"/bin/bash", "-c"},
+		expectedShell: strslice.StrSlice{"/bin/bash", "-c"},
+	},
+	{
+		shell:         []string{"powershell", "-command"},
+		expectedShell: strslice.StrSlice{"powershell", "-command"},
+	},
+	{
+		shell:     []string{},
+		shouldErr: true,
+	},
+}
+
+func TestExecuteShell(t *testing.T) {
+	for _, test := range shellTests {
+		cfg := &manifest.Schema2Config{}
+		cmd := ShellCommand{
+			&instructions.ShellCommand{
+				Shell: test.shell,
+			},
+		}
+		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expectedShell, cfg.Shell)
+	}
+}
+
+func TestShellUsedByRun(t *testing.T) {
+	cfg := &manifest.Schema2Config{}
+	shellCmd := ShellCommand{
+		&instructions.ShellCommand{
+			Shell: []string{"/bin/bash", "-c"},
+		},
+	}
+	if err := shellCmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{})); err != nil {
+		t.Fatal(err)
+	}
+	runCmd := RunCommand{
+		cmd: &instructions.RunCommand{
+			ShellDependantCmdLine: instructions.ShellDependantCmdLine{
+				PrependShell: true,
+				CmdLine:      []string{"true"},
+			},
+		},
+	}
+	err := runCmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+	testutil.CheckErrorAndDeepEqual(t, false, err, "/bin/bash -c true", runCmd.CreatedBy())
+}
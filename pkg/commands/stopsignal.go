@@ -0,0 +1,77 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package commands
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// signals are the names of the signals STOPSIGNAL accepts, without the SIG prefix
+var signals = map[string]struct{}{
+	"ABRT": {}, "ALRM": {}, "BUS": {}, "CHLD": {}, "CLD": {}, "CONT": {}, "FPE": {}, "HUP": {},
+	"ILL": {}, "INT": {}, "IO": {}, "IOT": {}, "KILL": {}, "PIPE": {}, "POLL": {}, "PROF": {},
+	"PWR": {}, "QUIT": {}, "SEGV": {}, "STKFLT": {}, "STOP": {}, "SYS": {}, "TERM": {}, "TRAP": {},
+	"TSTP": {}, "TTIN": {}, "TTOU": {}, "URG": {}, "USR1": {}, "USR2": {}, "VTALRM": {}, "WINCH": {},
+	"XCPU": {}, "XFSZ": {},
+}
+
+type StopSignalCommand struct {
+	cmd *instructions.StopSignalCommand
+}
+
+// ExecuteCommand sets the signal which will be used to stop the container
+// The signal may be given as a number or by name, with or without the SIG prefix.
+func (s *StopSignalCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
+	logrus.Info("cmd: STOPSIGNAL")
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	signal, err := util.ResolveEnvironmentReplacement(s.cmd.Signal, replacementEnvs, false)
+	if err != nil {
+		return err
+	}
+	if !validSignal(signal) {
+		return errors.Errorf("Invalid signal: %s", signal)
+	}
+	logrus.Infof("Replacing StopSignal in config with %s", signal)
+	config.StopSignal = signal
+	return nil
+}
+
+func validSignal(signal string) bool {
+	if num, err := strconv.Atoi(signal); err == nil {
+		return num > 0
+	}
+	_, ok := signals[strings.TrimPrefix(strings.ToUpper(signal), "SIG")]
+	return ok
+}
+
+// FilesToSnapshot returns an empty array since this is a metadata command
+func (s *StopSignalCommand) FilesToSnapshot() []string {
+	return []string{}
+}
+
+// CreatedBy returns some information about the command for the image config history
+func (s *StopSignalCommand) CreatedBy() string {
+	return strings.Join([]string{s.cmd.Name(), s.cmd.Signal}, " ")
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package commands
+
+import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"testing"
+)
+
+var stopSignalTests = []struct {
+	signal         string
+	expectedSignal string
+	shouldErr      bool
+}{
+	{
+		signal:         "SIGKILL",
+		expectedSignal: "SIGKILL",
+	},
+	{
+		signal:         "TERM",
+		expectedSignal: "TERM",
+	},
+	{
+		signal:         "9",
+		expectedSignal: "9",
+	},
+	{
+		signal:         "$SIGNAL",
+		expectedSignal: "SIGQUIT",
+	},
+	{
+		signal:    "SIGNOTASIGNAL",
+		shouldErr: true,
+	},
+	{
+		signal:    "0",
+		shouldErr: true,
+	},
+}
+
+func TestExecuteStopSignal(t *testing.T) {
+	for _, test := range stopSignalTests {
+		cfg := &manifest.Schema2Config{
+			Env: []string{"SIGNAL=SIGQUIT"},
+		}
+		cmd := StopSignalCommand{
+			&instructions.StopSignalCommand{
+				Signal: test.signal,
+			},
+		}
+		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
+		testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expectedSignal, cfg.StopSignal)
+	}
+}
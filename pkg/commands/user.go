@@ -17,11 +17,11 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
 	"github.com/sirupsen/logrus"
-	"os/user"
 	"strings"
 )
 
@@ -29,59 +29,30 @@ type UserCommand struct {
 	cmd *instructions.UserCommand
 }
 
-func (r *UserCommand) ExecuteCommand(config *manifest.Schema2Config) error {
+func (r *UserCommand) ExecuteCommand(config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) error {
 	logrus.Info("cmd: USER")
 	u := r.cmd.User
 	userAndGroup := strings.Split(u, ":")
-	userStr, err := util.ResolveEnvironmentReplacement(userAndGroup[0], config.Env, false)
+	replacementEnvs := buildArgs.ReplacementEnvs(config.Env)
+	userStr, err := util.ResolveEnvironmentReplacement(userAndGroup[0], replacementEnvs, false)
 	if err != nil {
 		return err
 	}
 	var groupStr string
 	if len(userAndGroup) > 1 {
-		groupStr, err = util.ResolveEnvironmentReplacement(userAndGroup[1], config.Env, false)
+		groupStr, err = util.ResolveEnvironmentReplacement(userAndGroup[1], replacementEnvs, false)
 		if err != nil {
 			return err
 		}
 	}
 
-	// Lookup by username
-	userObj, err := user.Lookup(userStr)
-	if err != nil {
-		if _, ok := err.(user.UnknownUserError); ok {
-			// Lookup by id
-			userObj, err = user.LookupId(userStr)
-			if err != nil {
-				return err
-			}
-		} else {
-			return err
-		}
-	}
-
-	// Same dance with groups
-	var group *user.Group
+	// Names are stored as they are, as docker does, and looked up in the image when commands run as the user,
+	// since the executor's own users may differ from the image's
 	if groupStr != "" {
-		group, err = user.LookupGroup(groupStr)
-		if err != nil {
-			if _, ok := err.(user.UnknownGroupError); ok {
-				group, err = user.LookupGroupId(groupStr)
-				if err != nil {
-					return err
-				}
-			} else {
-				return err
-			}
-		}
+		userStr = userStr + ":" + groupStr
 	}
-
-	uid := userObj.Uid
-	if group != nil {
-		uid = uid + ":" + group.Gid
-	}
-
-	logrus.Infof("Setting user to %s", uid)
-	config.User = uid
+	logrus.Infof("Setting user to %s", userStr)
+	config.User = userStr
 	return nil
 }
 
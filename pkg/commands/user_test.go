@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/GoogleCloudPlatform/kaniko/testutil"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
@@ -29,7 +30,7 @@ var userTests = []struct {
 }{
 	{
 		user:        "root",
-		expectedUid: "0",
+		expectedUid: "root",
 		shouldError: false,
 	},
 	{
@@ -38,23 +39,24 @@ var userTests = []struct {
 		shouldError: false,
 	},
 	{
+		// Users are looked up when commands run as them
 		user:        "fakeUser",
-		expectedUid: "",
-		shouldError: true,
+		expectedUid: "fakeUser",
+		shouldError: false,
 	},
 	{
 		user:        "root:root",
-		expectedUid: "0:0",
+		expectedUid: "root:root",
 		shouldError: false,
 	},
 	{
 		user:        "0:root",
-		expectedUid: "0:0",
+		expectedUid: "0:root",
 		shouldError: false,
 	},
 	{
 		user:        "root:0",
-		expectedUid: "0:0",
+		expectedUid: "root:0",
 		shouldError: false,
 	},
 	{
@@ -64,17 +66,17 @@ var userTests = []struct {
 	},
 	{
 		user:        "root:fakeGroup",
-		expectedUid: "",
-		shouldError: true,
+		expectedUid: "root:fakeGroup",
+		shouldError: false,
 	},
 	{
 		user:        "$envuser",
-		expectedUid: "0",
+		expectedUid: "root",
 		shouldError: false,
 	},
 	{
 		user:        "root:$envgroup",
-		expectedUid: "0:0",
+		expectedUid: "root:root",
 		shouldError: false,
 	},
 }
@@ -92,7 +94,7 @@ func TestUpdateUser(t *testing.T) {
 				User: test.user,
 			},
 		}
-		err := cmd.ExecuteCommand(cfg)
+		err := cmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
 		testutil.CheckErrorAndDeepEqual(t, test.shouldError, err, test.expectedUid, cfg.User)
 	}
 }
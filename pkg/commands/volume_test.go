@@ -16,6 +16,7 @@ limitations under the License.
 package commands
 
 import (
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
 	"github.com/GoogleCloudPlatform/kaniko/testutil"
 	"github.com/containers/image/manifest"
 	"github.com/docker/docker/builder/dockerfile/instructions"
@@ -49,6 +50,6 @@ func TestUpdateVolume(t *testing.T) {
 		"/etc":     {},
 	}
 
-	err := volumeCmd.ExecuteCommand(cfg)
+	err := volumeCmd.ExecuteCommand(cfg, dockerfile.NewBuildArgs([]string{}))
 	testutil.CheckErrorAndDeepEqual(t, false, err, expectedVolumes, cfg.Volumes)
 }
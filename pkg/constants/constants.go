@@ -20,6 +20,10 @@ const (
 	// DefaultLogLevel is the default log level
 	DefaultLogLevel = "info"
 
+	// LogFormatText and LogFormatJSON are the formats logs can be written in
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+
 	// RootDir is the path to the root directory
 	RootDir = "/"
 
@@ -36,14 +40,49 @@ const (
 	// ContextTar is the default name of the tar uploaded to GCS buckets
 	ContextTar = "context.tar.gz"
 
+	// Dockerignore is the name of the file listing the files to exclude from the build context
+	Dockerignore = ".dockerignore"
+
+	// SourceDateEpoch is the environment variable which sets the timestamps of reproducible builds, in seconds since the epoch
+	SourceDateEpoch = "SOURCE_DATE_EPOCH"
+
+	// DockerConfigEnv is the environment variable naming the directory of the docker config file, as with the docker CLI
+	DockerConfigEnv = "DOCKER_CONFIG"
+	// DefaultDockerConfigDir is the directory of the docker config file if DOCKER_CONFIG and HOME aren't set
+	DefaultDockerConfigDir = "/root/.docker"
+
 	// BuildContextDir is the directory a build context will be unpacked into,
 	// for example, a tarball from a GCS bucket will be unpacked here
 	BuildContextDir = "/kaniko/buildcontext/"
 
+	// KanikoIntermediateStagesDir is where the images built by earlier stages of a
+	// multi-stage build are saved as tarballs, for use by later stages
+	KanikoIntermediateStagesDir = "/kaniko/stages"
+
+	// KanikoLayersDir is where the layers built by commands, and layers retrieved from the cache, are stored
+	// until the image is pushed
+	KanikoLayersDir = "/kaniko/layers"
+
+	// KanikoBlobsDir caches the configs and compressed layers of base images, named by digest,
+	// so that each is downloaded once however many stages use it
+	KanikoBlobsDir = "/kaniko/blobs"
+
+	// KanikoImagesDir is where each base image is stored once it is resolved to the digest of its manifest,
+	// as a directory containing its manifest and links to its blobs
+	KanikoImagesDir = "/kaniko/images"
+
+	// KanikoCertsDir is where the certificates given for registries are linked to, since they can only be given as directories
+	KanikoCertsDir = "/kaniko/certs"
+
 	// Various snapshot modes:
 	SnapshotModeTime = "time"
 	SnapshotModeFull = "full"
+	// SnapshotModeTrace snapshots only the files RUN commands are traced changing, and is otherwise the same as full
+	SnapshotModeTrace = "trace"
 
 	// NoBaseImage is the scratch image
 	NoBaseImage = "scratch"
+
+	// WhiteoutPrefix is prepended to the name of a deleted file in a layer
+	WhiteoutPrefix = ".wh."
 )
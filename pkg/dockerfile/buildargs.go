@@ -0,0 +1,186 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+)
+
+// BuildArgs tracks the values of ARGs during a build
+// Values passed in with --build-arg override the defaults of declared ARGs.
+// ARGs declared before the first FROM can only be used in FROM instructions, unless they are
+// redeclared within a stage; ARGs declared within a stage are only in scope for the rest of that stage.
+type BuildArgs struct {
+	// flagArgs are the values passed in with --build-arg
+	flagArgs map[string]string
+	// metaArgs are the ARGs declared before the first FROM
+	metaArgs map[string]*string
+	// stageArgs are the ARGs declared in the current stage, in order of declaration
+	stageArgs []instructions.ArgCommand
+	// used records which values passed in with --build-arg were used
+	used map[string]bool
+}
+
+// NewBuildArgs creates BuildArgs from a list of --build-arg values in the form KEY=VALUE
+// If only KEY is given, the value is taken from the environment, as docker build does
+func NewBuildArgs(args []string) *BuildArgs {
+	flagArgs := make(map[string]string)
+	for _, arg := range args {
+		kv := strings.SplitN(arg, "=", 2)
+		if len(kv) == 2 {
+			flagArgs[kv[0]] = kv[1]
+			continue
+		}
+		if val, ok := os.LookupEnv(kv[0]); ok {
+			flagArgs[kv[0]] = val
+		}
+	}
+	return &BuildArgs{
+		flagArgs: flagArgs,
+		metaArgs: make(map[string]*string),
+		used:     make(map[string]bool),
+	}
+}
+
+// AddMetaArgs declares the ARGs which come before the first FROM
+// Their default values may refer to previously declared meta ARGs.
+func (b *BuildArgs) AddMetaArgs(metaArgs []instructions.ArgCommand) error {
+	for _, arg := range metaArgs {
+		value := arg.Value
+		if value != nil {
+			resolved, err := util.ResolveEnvironmentReplacement(*value, b.MetaEnvs(), false)
+			if err != nil {
+				return err
+			}
+			value = &resolved
+		}
+		b.metaArgs[arg.Key] = value
+	}
+	return nil
+}
+
+// MetaEnvs returns the values of the meta ARGs in the form KEY=VALUE, for resolving FROM instructions
+func (b *BuildArgs) MetaEnvs() []string {
+	var envs []string
+	for _, key := range sortedKeys(b.metaArgs) {
+		if val, ok := b.value(key, b.metaArgs[key]); ok {
+			envs = append(envs, key+"="+val)
+		}
+	}
+	return envs
+}
+
+// ResetStage removes all ARGs declared in the current stage, since they go out of scope once a stage ends
+func (b *BuildArgs) ResetStage() {
+	b.stageArgs = nil
+}
+
+// AddArg declares an ARG within the current stage, with an optional default value
+func (b *BuildArgs) AddArg(key string, value *string) {
+	b.stageArgs = append(b.stageArgs, instructions.ArgCommand{
+		Key:   key,
+		Value: value,
+	})
+}
+
+// Envs returns the values of the ARGs in scope in the current stage, in the form KEY=VALUE
+// ARGs without a default value which weren't passed in with --build-arg take the value of the
+// meta ARG of the same name, if there is one; otherwise they are left unset.
+func (b *BuildArgs) Envs() []string {
+	values := make(map[string]string)
+	var keys []string
+	for _, arg := range b.stageArgs {
+		defaultValue := arg.Value
+		if defaultValue == nil {
+			defaultValue = b.metaArgs[arg.Key]
+		}
+		val, ok := b.value(arg.Key, defaultValue)
+		if !ok {
+			continue
+		}
+		if _, exists := values[arg.Key]; !exists {
+			keys = append(keys, arg.Key)
+		}
+		values[arg.Key] = val
+	}
+	var envs []string
+	for _, key := range keys {
+		envs = append(envs, key+"="+values[key])
+	}
+	return envs
+}
+
+// ReplacementEnvs returns the ARGs in scope combined with envs (usually config.Env), for resolving
+// environment replacement or running commands
+// ENV variables take precedence over ARGs of the same name.
+func (b *BuildArgs) ReplacementEnvs(envs []string) []string {
+	var combined []string
+	for _, arg := range b.Envs() {
+		key := strings.SplitN(arg, "=", 2)[0]
+		if !envsContainKey(envs, key) {
+			combined = append(combined, arg)
+		}
+	}
+	return append(combined, envs...)
+}
+
+// UnusedFlagArgs returns the names of any values passed in with --build-arg which no ARG used
+func (b *BuildArgs) UnusedFlagArgs() []string {
+	var unused []string
+	for key := range b.flagArgs {
+		if !b.used[key] {
+			unused = append(unused, key)
+		}
+	}
+	sort.Strings(unused)
+	return unused
+}
+
+// value returns the value of the ARG key, preferring the value passed in with --build-arg over defaultValue
+func (b *BuildArgs) value(key string, defaultValue *string) (string, bool) {
+	if val, ok := b.flagArgs[key]; ok {
+		b.used[key] = true
+		return val, true
+	}
+	if defaultValue != nil {
+		return *defaultValue, true
+	}
+	return "", false
+}
+
+func envsContainKey(envs []string, key string) bool {
+	for _, env := range envs {
+		if strings.SplitN(env, "=", 2)[0] == key {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]*string) []string {
+	var keys []string
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+)
+
+func Test_BuildArgsMetaArgs(t *testing.T) {
+	dockerfile := `
+	ARG REGISTRY=gcr.io
+	ARG IMAGE=$REGISTRY/distroless/base
+	ARG TAG
+	FROM ${IMAGE}:${TAG}
+	ARG TAG
+	ARG IMAGE
+	ARG UNSET
+	`
+	stages, metaArgs, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	buildArgs := NewBuildArgs([]string{"TAG=debug", "UNUSED=foo"})
+	if err := buildArgs.AddMetaArgs(metaArgs); err != nil {
+		t.Fatal(err)
+	}
+	err = ResolveBaseNames(stages, buildArgs)
+	testutil.CheckErrorAndDeepEqual(t, false, err, "gcr.io/distroless/base:debug", stages[0].BaseName)
+
+	// Meta args are only in scope within a stage once they are redeclared
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string(nil), buildArgs.Envs())
+	buildArgs.AddArg("TAG", nil)
+	buildArgs.AddArg("IMAGE", nil)
+	buildArgs.AddArg("UNSET", nil)
+	expectedEnvs := []string{"TAG=debug", "IMAGE=gcr.io/distroless/base"}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expectedEnvs, buildArgs.Envs())
+
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"UNUSED"}, buildArgs.UnusedFlagArgs())
+
+	// Stage args go out of scope at the end of a stage
+	buildArgs.ResetStage()
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string(nil), buildArgs.Envs())
+}
+
+func Test_BuildArgsReplacementEnvs(t *testing.T) {
+	buildArgs := NewBuildArgs([]string{"flag=fromflag"})
+	buildArgs.AddArg("flag", stringPtr("default"))
+	buildArgs.AddArg("arg", stringPtr("default"))
+	buildArgs.AddArg("env", stringPtr("default"))
+
+	envs := []string{"env=fromenv"}
+	expected := []string{"flag=fromflag", "arg=default", "env=fromenv"}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, buildArgs.ReplacementEnvs(envs))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"env=fromenv"}, envs)
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -18,23 +18,39 @@ package dockerfile
 
 import (
 	"bytes"
+	"strconv"
 	"strings"
 
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/docker/docker/builder/dockerfile/instructions"
 	"github.com/docker/docker/builder/dockerfile/parser"
+	"github.com/pkg/errors"
 )
 
-// Parse parses the contents of a Dockerfile and returns a list of commands
-func Parse(b []byte) ([]instructions.Stage, error) {
+// Parse parses the contents of a Dockerfile and returns a list of stages,
+// along with any ARGs declared before the first FROM
+func Parse(b []byte) ([]instructions.Stage, []instructions.ArgCommand, error) {
 	p, err := parser.Parse(bytes.NewReader(b))
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	stages, _, err := instructions.Parse(p.AST)
+	stages, metaArgs, err := instructions.Parse(p.AST)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return stages, metaArgs, err
+}
+
+// ResolveBaseNames replaces any ARGs in the FROM instruction of each stage with their values
+func ResolveBaseNames(stages []instructions.Stage, buildArgs *BuildArgs) error {
+	for i, stage := range stages {
+		resolved, err := util.ResolveEnvironmentReplacement(stage.BaseName, buildArgs.MetaEnvs(), false)
+		if err != nil {
+			return err
+		}
+		stages[i].BaseName = resolved
 	}
-	return stages, err
+	return nil
 }
 
 // ParseCommands parses an array of commands into an array of instructions.Command; used for onbuild
@@ -54,3 +70,87 @@ func ParseCommands(cmdArray []string) ([]instructions.Command, error) {
 	}
 	return cmds, nil
 }
+
+// ResolveStages replaces any references to previous stages by name with the index of that stage
+// Ex. if the first stage is named "builder", FROM builder and COPY --from=builder both become 0
+func ResolveStages(stages []instructions.Stage) error {
+	nameToIndex := make(map[string]string)
+	for i, stage := range stages {
+		index := strconv.Itoa(i)
+		if base, ok := nameToIndex[strings.ToLower(stage.BaseName)]; ok {
+			stages[i].BaseName = base
+		}
+		for _, cmd := range stage.Commands {
+			c, ok := cmd.(*instructions.CopyCommand)
+			if !ok || c.From == "" {
+				continue
+			}
+			if from, ok := nameToIndex[strings.ToLower(c.From)]; ok {
+				c.From = from
+				continue
+			}
+			if from, err := strconv.Atoi(c.From); err != nil || from < 0 || from >= i {
+				return errors.Errorf("COPY --from=%s does not refer to a previous build stage", c.From)
+			}
+		}
+		if stage.Name != "" {
+			nameToIndex[stage.Name] = index
+		}
+	}
+	return nil
+}
+
+// SaveStage returns true if the stage at index is used by a later stage,
+// either as a base image or as the source of a COPY --from
+func SaveStage(index int, stages []instructions.Stage) bool {
+	stageIndex := strconv.Itoa(index)
+	for _, stage := range stages[index+1:] {
+		if stage.BaseName == stageIndex {
+			return true
+		}
+		for _, cmd := range stage.Commands {
+			if c, ok := cmd.(*instructions.CopyCommand); ok && c.From == stageIndex {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TargetStage returns the index of the stage named target, or of the last stage if target is empty
+func TargetStage(stages []instructions.Stage, target string) (int, error) {
+	if target == "" {
+		return len(stages) - 1, nil
+	}
+	for i, stage := range stages {
+		// Stage names are lowercased when they are parsed
+		if stage.Name == strings.ToLower(target) {
+			return i, nil
+		}
+	}
+	return -1, errors.Errorf("target stage %s could not be found", target)
+}
+
+// StagesToBuild returns whether each stage up to the one at target is needed to build it:
+// the target itself, and the stages it depends on through FROM or COPY --from, directly or through other stages
+// The stages must have been resolved with ResolveStages.
+func StagesToBuild(stages []instructions.Stage, target int) []bool {
+	build := make([]bool, target+1)
+	build[target] = true
+	for i := target; i > 0; i-- {
+		if !build[i] {
+			continue
+		}
+		if base, err := strconv.Atoi(stages[i].BaseName); err == nil && base < i {
+			build[base] = true
+		}
+		for _, cmd := range stages[i].Commands {
+			if c, ok := cmd.(*instructions.CopyCommand); ok && c.From != "" {
+				if from, err := strconv.Atoi(c.From); err == nil && from < i {
+					build[from] = true
+				}
+			}
+		}
+	}
+	return build
+}
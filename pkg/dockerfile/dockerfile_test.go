@@ -0,0 +1,173 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dockerfile
+
+import (
+	"testing"
+
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+)
+
+func Test_ResolveStages(t *testing.T) {
+	dockerfile := `
+	FROM scratch AS Builder
+	RUN echo hi > /hi
+
+	FROM scratch
+	COPY --from=builder /hi /hi
+
+	FROM builder AS second
+	COPY --from=0 /hi /hi2
+
+	FROM scratch
+	COPY --from=second /hi2 /hi3
+	`
+	stages, _, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ResolveStages(stages)
+	testutil.CheckError(t, false, err)
+
+	expectedBaseNames := []string{"scratch", "scratch", "0", "scratch"}
+	expectedFrom := []string{"", "0", "0", "2"}
+	for i, stage := range stages {
+		testutil.CheckErrorAndDeepEqual(t, false, nil, expectedBaseNames[i], stage.BaseName)
+		from := ""
+		for _, cmd := range stage.Commands {
+			if c, ok := cmd.(*instructions.CopyCommand); ok {
+				from = c.From
+			}
+		}
+		testutil.CheckErrorAndDeepEqual(t, false, nil, expectedFrom[i], from)
+	}
+}
+
+func Test_ResolveStagesInvalidFrom(t *testing.T) {
+	tests := []string{
+		`
+		FROM scratch
+		COPY --from=later /hi /hi
+		FROM scratch AS later
+		`,
+		`
+		FROM scratch
+		COPY --from=0 /hi /hi
+		`,
+		`
+		FROM scratch
+		COPY --from=gcr.io/distroless/base /hi /hi
+		`,
+	}
+	for _, test := range tests {
+		stages, _, err := Parse([]byte(test))
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.CheckError(t, true, ResolveStages(stages))
+	}
+}
+
+func Test_SaveStage(t *testing.T) {
+	dockerfile := `
+	FROM scratch AS first
+	RUN echo hi > /hi
+
+	FROM scratch AS second
+	COPY --from=first /hi /hi
+
+	FROM scratch AS unused
+	RUN echo unused
+
+	FROM second
+	RUN echo final
+	`
+	stages, _, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ResolveStages(stages); err != nil {
+		t.Fatal(err)
+	}
+	expected := []bool{true, true, false, false}
+	for index := range stages {
+		testutil.CheckErrorAndDeepEqual(t, false, nil, expected[index], SaveStage(index, stages))
+	}
+}
+
+func Test_StagesToBuild(t *testing.T) {
+	dockerfile := `
+	FROM scratch AS first
+	RUN echo hi > /hi
+
+	FROM scratch AS unused
+	RUN echo unused
+
+	FROM scratch AS second
+	COPY --from=first /hi /hi
+
+	FROM second AS Test
+	RUN echo test
+
+	FROM scratch
+	COPY --from=second /hi /hi
+	`
+	stages, _, err := Parse([]byte(dockerfile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ResolveStages(stages); err != nil {
+		t.Fatal(err)
+	}
+	var tests = []struct {
+		target    string
+		expected  []bool
+		shouldErr bool
+	}{
+		{
+			target:   "test",
+			expected: []bool{true, false, true, true},
+		},
+		{
+			target:   "Second",
+			expected: []bool{true, false, true},
+		},
+		{
+			target:   "unused",
+			expected: []bool{false, true},
+		},
+		{
+			target:   "",
+			expected: []bool{true, false, true, false, true},
+		},
+		{
+			target:    "missing",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.target, func(t *testing.T) {
+			target, err := TargetStage(stages, test.target)
+			var actual []bool
+			if err == nil {
+				actual = StagesToBuild(stages, target)
+			}
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, actual)
+		})
+	}
+}
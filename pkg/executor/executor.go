@@ -17,10 +17,15 @@ limitations under the License.
 package executor
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/GoogleCloudPlatform/kaniko/pkg/cache"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/commands"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
@@ -28,89 +33,446 @@ import (
 	"github.com/GoogleCloudPlatform/kaniko/pkg/snapshot"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/docker/distribution/reference"
 	"github.com/docker/docker/builder/dockerfile/instructions"
+	digest "github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 )
 
-func DoBuild(dockerfilePath, srcContext, destination, snapshotMode string) error {
-	// Parse dockerfile and unpack base image to root
-	d, err := ioutil.ReadFile(dockerfilePath)
+// rootDir is the file system stages are built in, and layersDir is where the layers they create are stored
+// They are only changed by tests, which can't build in the root of the file system.
+var (
+	rootDir   = constants.RootDir
+	layersDir = constants.KanikoLayersDir
+)
+
+// KanikoBuildArgs contains the options for a build
+type KanikoBuildArgs struct {
+	DockerfilePath string
+	SrcContext     string
+	Destinations   []string
+	SnapshotMode   string
+	Args           []string
+	// Cache enables the layer cache, which stores the layers created by RUN, ADD and COPY in CacheRepo
+	Cache     bool
+	CacheRepo string
+	// TarPath and OCILayoutPath are paths to write the image to, as a docker-save compatible tarball
+	// and an OCI image layout respectively, tagged with the first destination
+	TarPath       string
+	OCILayoutPath string
+	// NoPush disables pushing the image to Destinations
+	NoPush bool
+	// Reproducible gives every file in the layers and every entry in the history the timestamp set by SOURCE_DATE_EPOCH,
+	// or the epoch, so that building the same context twice produces the same image
+	Reproducible bool
+	// Registry configures the credentials used to pull base images, push the image and access the layer cache
+	Registry util.RegistryOptions
+	// DigestFile, ImageNameWithDigestFile and BuildReportFile are paths to write the digest of the image,
+	// its destinations referred to by digest, and a JSON report describing it to, once it is pushed or saved
+	DigestFile              string
+	ImageNameWithDigestFile string
+	BuildReportFile         string
+	// CacheDir is where the blobs of base images are stored, keyed by digest, so that they can be reused by later builds
+	CacheDir string
+	// Target is the name of the stage to build, instead of the last stage.
+	// Only the stages it depends on are built with it, and its image is the one which is pushed or saved.
+	Target string
+}
+
+func DoBuild(k KanikoBuildArgs) error {
+	stages, buildArgs, err := parseStages(k)
 	if err != nil {
 		return err
 	}
 
-	stages, err := dockerfile.Parse(d)
+	if err := util.LoadDockerignore(k.SrcContext); err != nil {
+		return err
+	}
+	if err := util.SetRegistryOptions(k.Registry); err != nil {
+		return err
+	}
+	util.SetCacheDir(k.CacheDir)
+	if !k.NoPush {
+		if err := util.ResolveCredentials(k.Destinations); err != nil {
+			return err
+		}
+	}
+	hasher, err := getHasher(k.SnapshotMode)
 	if err != nil {
 		return err
 	}
-	baseImage := stages[0].BaseName
+	var created *time.Time
+	if k.Reproducible {
+		t, err := util.SourceDateEpoch()
+		if err != nil {
+			return err
+		}
+		logrus.Infof("Building reproducibly with timestamp %s", t)
+		created = &t
+	}
+	util.SetReproducibleTime(created)
+	var layerCache *cache.LayerCache
+	if k.Cache {
+		logrus.Infof("Using layer cache %s", k.CacheRepo)
+		ref, err := reference.ParseNormalizedNamed(k.CacheRepo)
+		if err != nil {
+			return err
+		}
+		sys, err := util.NewSystemContext(reference.Domain(ref))
+		if err != nil {
+			return err
+		}
+		layerCache = cache.NewLayerCache(k.CacheRepo, layersDir, sys)
+	}
+	// baseImages are the images each stage was built from, which for stages built from earlier stages
+	// are the images those stages were built from
+	baseImages := make([]*image.BaseImage, len(stages))
+	build := stagesToBuild(stages, k)
+	for index, stage := range stages {
+		if !build[index] {
+			logrus.Infof("Skipping stage %d, which target stage %s doesn't depend on", index, k.Target)
+			continue
+		}
+		finalStage := index == len(stages)-1
+		sourceImage, base, err := buildStage(index, stage, k, hasher, buildArgs, layerCache, created)
+		if err != nil {
+			return err
+		}
+		baseImages[index] = base
+		if previous, err := strconv.Atoi(stage.BaseName); err == nil && previous < index {
+			baseImages[index] = baseImages[previous]
+		}
+		if created != nil {
+			sourceImage.SetCreated(*created)
+		}
+		if finalStage {
+			if unused := buildArgs.UnusedFlagArgs(); len(unused) > 0 {
+				logrus.Warnf("One or more build args were not consumed: %v", unused)
+			}
+			err := saveImage(sourceImage, baseImages[index], k)
+			if removeErr := deleteLayers(); err == nil {
+				err = removeErr
+			}
+			return err
+		}
+		if dockerfile.SaveStage(index, stages) {
+			if err := image.SaveStage(sourceImage, index); err != nil {
+				return err
+			}
+			if err := util.ExtractFileSystemFromStage(index); err != nil {
+				return err
+			}
+		}
+		if err := deleteLayers(); err != nil {
+			return err
+		}
+		// Delete the file system, so the next stage starts from its own base image
+		if err := util.DeleteFilesystem(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	// Unpack file system to root
-	logrus.Infof("Unpacking filesystem of %s...", baseImage)
-	if err := util.ExtractFileSystemFromImage(baseImage); err != nil {
-		return err
+// deleteLayers removes the files of the layers a stage built or retrieved from the cache,
+// once its image has been pushed or saved and they're no longer needed
+func deleteLayers() error {
+	return os.RemoveAll(layersDir)
+}
+
+// parseStages parses the Dockerfile into its stages, resolving ARGs in FROM instructions and references to earlier stages,
+// and returns the stages up to the target stage with the build args
+func parseStages(k KanikoBuildArgs) ([]instructions.Stage, *dockerfile.BuildArgs, error) {
+	d, err := ioutil.ReadFile(k.DockerfilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+	stages, metaArgs, err := dockerfile.Parse(d)
+	if err != nil {
+		return nil, nil, err
+	}
+	buildArgs := dockerfile.NewBuildArgs(k.Args)
+	if err := buildArgs.AddMetaArgs(metaArgs); err != nil {
+		return nil, nil, err
+	}
+	if err := dockerfile.ResolveBaseNames(stages, buildArgs); err != nil {
+		return nil, nil, err
+	}
+	if err := dockerfile.ResolveStages(stages); err != nil {
+		return nil, nil, err
+	}
+	target, err := dockerfile.TargetStage(stages, k.Target)
+	if err != nil {
+		return nil, nil, err
+	}
+	return stages[:target+1], buildArgs, nil
+}
+
+// stagesToBuild returns whether each stage should be built: every stage unless there is a target stage,
+// in which case only the target and the stages it depends on
+func stagesToBuild(stages []instructions.Stage, k KanikoBuildArgs) []bool {
+	if k.Target == "" {
+		build := make([]bool, len(stages))
+		for i := range build {
+			build[i] = true
+		}
+		return build
 	}
+	return dockerfile.StagesToBuild(stages, len(stages)-1)
+}
 
-	hasher, err := getHasher(snapshotMode)
+// saveImage writes the image built by the final stage from base to each of the outputs requested,
+// pushes it unless pushing is disabled, and then writes the digest files and build report requested
+func saveImage(sourceImage *image.MutableSource, base *image.BaseImage, k KanikoBuildArgs) error {
+	var destination string
+	if len(k.Destinations) > 0 {
+		destination = k.Destinations[0]
+	}
+	if k.TarPath != "" {
+		if err := image.SaveImageToTarball(sourceImage, k.TarPath, destination); err != nil {
+			return err
+		}
+	}
+	if k.OCILayoutPath != "" {
+		if err := image.SaveImageToOCILayout(sourceImage, k.OCILayoutPath, destination); err != nil {
+			return err
+		}
+	}
+	var digests []digest.Digest
+	if k.NoPush {
+		logrus.Info("Skipping push to container registry due to --no-push flag")
+	} else {
+		var err error
+		if digests, err = image.PushImage(sourceImage, k.Destinations); err != nil {
+			return err
+		}
+	}
+	if k.DigestFile == "" && k.ImageNameWithDigestFile == "" && k.BuildReportFile == "" {
+		return nil
+	}
+	report, err := image.NewBuildReport(sourceImage, base, k.Destinations, digests)
 	if err != nil {
 		return err
 	}
+	logrus.Infof("Built image with digest %s", report.Digest)
+	if k.DigestFile != "" {
+		if err := report.WriteDigest(k.DigestFile); err != nil {
+			return err
+		}
+	}
+	if k.ImageNameWithDigestFile != "" {
+		if err := report.WriteImageNamesWithDigest(k.ImageNameWithDigestFile); err != nil {
+			return err
+		}
+	}
+	if k.BuildReportFile != "" {
+		return report.WriteJSON(k.BuildReportFile)
+	}
+	return nil
+}
+
+// buildStage unpacks the base image of the stage at index to root and executes its commands,
+// returning the resulting image and the base image it was built from
+// If layerCache isn't nil, the layers created by RUN, ADD and COPY are retrieved from it instead of executing the command
+// when possible, and are pushed to it otherwise. created is the timestamp of the files in the layers if the build is reproducible.
+func buildStage(index int, stage instructions.Stage, k KanikoBuildArgs, hasher func(string) (string, error), buildArgs *dockerfile.BuildArgs, layerCache *cache.LayerCache, created *time.Time) (*image.MutableSource, *image.BaseImage, error) {
+	baseImage := stage.BaseName
+	// ARGs declared in previous stages are out of scope
+	buildArgs.ResetStage()
+
+	// Resolve the base image to a digest and download it, so that the file system and the source image are the same image
+	var baseRef types.ImageReference
+	var base *image.BaseImage
+	if baseImage != constants.NoBaseImage {
+		logrus.Infof("Pulling base image %s", baseImage)
+		var err error
+		if baseRef, err = util.PullImage(baseImage); err != nil {
+			return nil, nil, err
+		}
+		if base, err = image.NewBaseImage(baseImage, baseRef); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Unpack file system to root
+	logrus.Infof("Unpacking filesystem of %s...", baseImage)
+	if err := util.ExtractFileSystemFromImage(baseRef); err != nil {
+		return nil, nil, err
+	}
+
 	l := snapshot.NewLayeredMap(hasher)
-	snapshotter := snapshot.NewSnapshotter(l, constants.RootDir)
+	snapshotter := snapshot.NewSnapshotter(l, rootDir, layersDir)
 
 	// Take initial snapshot
 	if err := snapshotter.Init(); err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Initialize source image
-	sourceImage, err := image.NewSourceImage(baseImage)
+	sourceImage, err := image.NewSourceImage(baseRef)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	// Set environment variables within the image
 	if err := image.SetEnvVariables(sourceImage); err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	imageConfig := sourceImage.Config()
-	// Currently only supports single stage builds
-	for _, stage := range stages {
-		if err := resolveOnBuild(&stage, imageConfig); err != nil {
-			return err
+	if err := resolveOnBuild(&stage, imageConfig); err != nil {
+		return nil, nil, err
+	}
+	var cacheKey string
+	if layerCache != nil {
+		cacheKey, err = baseCacheKey(sourceImage, created)
+		if err != nil {
+			return nil, nil, err
 		}
-		for _, cmd := range stage.Commands {
-			dockerCommand, err := commands.GetCommand(cmd, srcContext)
-			if err != nil {
-				return err
-			}
-			if err := dockerCommand.ExecuteCommand(imageConfig); err != nil {
-				return err
-			}
-			// Now, we get the files to snapshot from this command and take the snapshot
-			snapshotFiles := dockerCommand.FilesToSnapshot()
-			contents, err := snapshotter.TakeSnapshot(snapshotFiles)
+	}
+	for i, cmd := range stage.Commands {
+		s := startStep(index, i+1, len(stage.Commands), cmd)
+		dockerCommand, err := commands.GetCommand(cmd, k.SrcContext)
+		if err != nil {
+			return nil, nil, s.fail(err)
+		}
+		if run, ok := dockerCommand.(*commands.RunCommand); ok && k.SnapshotMode == constants.SnapshotModeTrace {
+			run.EnableTracing()
+		}
+		useCache := layerCache != nil && cacheable(dockerCommand, imageConfig, buildArgs)
+		if layerCache != nil && !useCache && changesFiles(dockerCommand) {
+			// The files the command changes aren't part of the cache key, so the keys of later commands can't depend on them
+			logrus.Infof("Not using the layer cache for the rest of stage %d, since %s can't be cached", index, dockerCommand.CreatedBy())
+			layerCache = nil
+		}
+		if useCache {
+			cacheKey, err = commandCacheKey(cacheKey, dockerCommand, imageConfig, buildArgs)
 			if err != nil {
-				return err
+				return nil, nil, s.fail(err)
 			}
-			util.MoveVolumeWhitelistToWhitelist()
-			if contents == nil {
-				logrus.Info("No files were changed, appending empty layer to config.")
-				sourceImage.AppendConfigHistory(constants.Author, true)
+			cached, err := layerCache.RetrieveLayer(cacheKey)
+			if err == nil {
+				logrus.Infof("Using cached layer for %s", dockerCommand.CreatedBy())
+				if err := applyCachedLayer(cached, sourceImage, imageConfig, snapshotter); err != nil {
+					return nil, nil, s.fail(err)
+				}
+				s.finish(cached.Layer, true)
 				continue
 			}
-			// Append the layer to the image
-			if err := sourceImage.AppendLayer(contents, constants.Author); err != nil {
-				return err
+			logrus.Infof("No cached layer found for %s, executing command", dockerCommand.CreatedBy())
+			logrus.Debugf("Error retrieving cached layer %s: %s", cacheKey, err)
+		}
+		if err := dockerCommand.ExecuteCommand(imageConfig, buildArgs); err != nil {
+			return nil, nil, s.fail(err)
+		}
+		// Now, we get the files to snapshot from this command and take the snapshot
+		snapshotFiles := dockerCommand.FilesToSnapshot()
+		layer, err := snapshotter.TakeSnapshot(snapshotFiles)
+		if err != nil {
+			return nil, nil, s.fail(err)
+		}
+		util.MoveVolumeWhitelistToWhitelist()
+		if useCache {
+			// Failing to update the cache only makes later builds slower, so it shouldn't fail this one
+			if err := layerCache.PushLayer(cacheKey, layer, imageConfig); err != nil {
+				logrus.Warnf("Error pushing layer to cache: %s", err)
 			}
 		}
+		if layer == nil {
+			logrus.Info("No files were changed, appending empty layer to config.")
+			sourceImage.AppendConfigHistory(constants.Author, true)
+			s.finish(nil, false)
+			continue
+		}
+		// Append the layer to the image
+		sourceImage.AppendLayer(layer)
+		s.finish(layer, false)
 	}
-	// Push the image
-	if err := setDefaultEnv(); err != nil {
+	return sourceImage, base, nil
+}
+
+// cacheable returns true if the layer created by the command, executed with config and buildArgs, should be cached
+// Other commands only change the config, so they're quick to execute. ADD commands with remote URLs aren't cached,
+// since the contents of the URLs aren't part of the cache key, so a cached layer could be stale;
+// for the same reason, the cache isn't used for the rest of the stage once one has been executed.
+func cacheable(cmd commands.DockerCommand, config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) bool {
+	switch c := cmd.(type) {
+	case *commands.RunCommand, *commands.CopyCommand:
+		return true
+	case *commands.AddCommand:
+		return !c.UsesRemoteURLs(config, buildArgs)
+	}
+	return false
+}
+
+// changesFiles returns true if the command can change the filesystem, rather than only the config
+func changesFiles(cmd commands.DockerCommand) bool {
+	switch cmd.(type) {
+	case *commands.RunCommand, *commands.CopyCommand, *commands.AddCommand:
+		return true
+	}
+	return false
+}
+
+// baseCacheKey returns the digest of the manifest of the base image, which the cache keys of the commands in a stage build on
+// Layers built reproducibly are cached separately, since the timestamps of their files differ.
+func baseCacheKey(sourceImage *image.MutableSource, created *time.Time) (string, error) {
+	m, _, err := sourceImage.GetManifest(nil)
+	if err != nil {
+		return "", err
+	}
+	key := digest.FromBytes(m).String()
+	if created != nil {
+		key = cache.Key(key, "reproducible", created.Format(time.RFC3339))
+	}
+	return key, nil
+}
+
+// commandCacheKey returns the cache key of a command, which depends on the key of the previous cached command,
+// the config and ARGs the command runs with, the command itself and the contents of any files it uses from the build context
+func commandCacheKey(prevKey string, cmd commands.DockerCommand, config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) (string, error) {
+	configJSON, err := json.Marshal(config)
+	if err != nil {
+		return "", err
+	}
+	parts := []string{string(configJSON), strings.Join(buildArgs.Envs(), "\n"), cmd.CreatedBy()}
+	if c, ok := cmd.(commands.ContextCommand); ok {
+		files, err := c.FilesUsedFromContext(config, buildArgs)
+		if err != nil {
+			return "", err
+		}
+		hasher := util.CacheHasher()
+		for _, file := range files {
+			hash, err := hasher(file)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, file+":"+hash)
+		}
+	}
+	return cache.Key(prevKey, parts...), nil
+}
+
+// applyCachedLayer extracts a cached layer to root and appends it and its config to the image,
+// as if the command which created it had been executed
+func applyCachedLayer(cached *cache.CachedLayer, sourceImage *image.MutableSource, config *manifest.Schema2Config, snapshotter *snapshot.Snapshotter) error {
+	if cached.Config != nil {
+		*config = *cached.Config
+	}
+	if cached.Layer == nil {
+		sourceImage.AppendConfigHistory(constants.Author, true)
+		return nil
+	}
+	if err := util.ExtractLayer(cached.Layer); err != nil {
+		return err
+	}
+	// Record the extracted files, so they aren't added to the layers of later commands
+	if err := snapshotter.SkipChanges(); err != nil {
 		return err
 	}
-	return image.PushImage(sourceImage, destination)
+	sourceImage.AppendLayer(cached.Layer)
+	return nil
 }
 
 func getHasher(snapshotMode string) (func(string) (string, error), error) {
@@ -121,6 +483,10 @@ func getHasher(snapshotMode string) (func(string) (string, error), error) {
 	if snapshotMode == constants.SnapshotModeFull {
 		return util.Hasher(), nil
 	}
+	if snapshotMode == constants.SnapshotModeTrace {
+		logrus.Info("RUN commands will be traced to find the files they change")
+		return util.Hasher(), nil
+	}
 	return nil, fmt.Errorf("%s is not a valid snapshot mode", snapshotMode)
 }
 
@@ -138,18 +504,3 @@ func resolveOnBuild(stage *instructions.Stage, config *manifest.Schema2Config) e
 	logrus.Infof("Executing %v build triggers", len(cmds))
 	return nil
 }
-
-// setDefaultEnv sets default values for HOME and PATH so that
-// config.json and docker-credential-gcr can be accessed
-func setDefaultEnv() error {
-	defaultEnvs := map[string]string{
-		"HOME": "/root",
-		"PATH": "/usr/local/bin/",
-	}
-	for key, val := range defaultEnvs {
-		if err := os.Setenv(key, val); err != nil {
-			return err
-		}
-	}
-	return nil
-}
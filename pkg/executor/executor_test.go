@@ -0,0 +1,308 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package executor
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/commands"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func copyCacheKey(t *testing.T, buildcontext string, config *manifest.Schema2Config) string {
+	cmd, err := commands.GetCommand(&instructions.CopyCommand{
+		SourcesAndDest: []string{"foo", "/foo"},
+	}, buildcontext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := commandCacheKey("base", cmd, config, dockerfile.NewBuildArgs([]string{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func Test_commandCacheKey(t *testing.T) {
+	buildcontext, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(buildcontext)
+	if err := testutil.SetupFiles(buildcontext, map[string]string{"foo": "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	config := &manifest.Schema2Config{}
+	key := copyCacheKey(t, buildcontext, config)
+
+	// Changing the mtime of a source file shouldn't change the key
+	later := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(buildcontext, "foo"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, key, copyCacheKey(t, buildcontext, config))
+
+	// Changing the config should
+	if copyCacheKey(t, buildcontext, &manifest.Schema2Config{WorkingDir: "/app"}) == key {
+		t.Error("Expected cache key to change with the config")
+	}
+
+	// Changing the contents of a source file should
+	if err := testutil.SetupFiles(buildcontext, map[string]string{"foo": "goodbye"}); err != nil {
+		t.Fatal(err)
+	}
+	if copyCacheKey(t, buildcontext, config) == key {
+		t.Error("Expected cache key to change with the contents of the source files")
+	}
+}
+
+func Test_cacheable(t *testing.T) {
+	var tests = []struct {
+		name     string
+		cmd      instructions.Command
+		expected bool
+	}{
+		{
+			name:     "run",
+			cmd:      &instructions.RunCommand{},
+			expected: true,
+		},
+		{
+			name:     "copy",
+			cmd:      &instructions.CopyCommand{SourcesAndDest: []string{"foo", "/foo"}},
+			expected: true,
+		},
+		{
+			name:     "add from the build context",
+			cmd:      &instructions.AddCommand{SourcesAndDest: []string{"foo", "/foo"}},
+			expected: true,
+		},
+		{
+			name:     "add from a url",
+			cmd:      &instructions.AddCommand{SourcesAndDest: []string{"foo", "https://example.com/foo.tar.gz", "/foo"}},
+			expected: false,
+		},
+		{
+			name:     "add from a url in an arg",
+			cmd:      &instructions.AddCommand{SourcesAndDest: []string{"$URL", "/foo"}},
+			expected: false,
+		},
+		{
+			name:     "env",
+			cmd:      &instructions.EnvCommand{},
+			expected: false,
+		},
+	}
+	buildArgs := dockerfile.NewBuildArgs([]string{"URL=http://example.com/foo"})
+	buildArgs.AddArg("URL", nil)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cmd, err := commands.GetCommand(test.cmd, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, nil, test.expected, cacheable(cmd, &manifest.Schema2Config{}, buildArgs))
+		})
+	}
+}
+
+// buildDigest builds a Dockerfile which copies files written at mtime from the build context into dir,
+// and returns the digest of the image
+func buildDigest(t *testing.T, dir string, files map[string]string, mtime time.Time, reproducible bool) string {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "root")
+	context := filepath.Join(dir, "context")
+	if err := testutil.SetupFiles(context, files); err != nil {
+		t.Fatal(err)
+	}
+	for name := range files {
+		path := filepath.Join(context, name)
+		if err := os.Chtimes(path, mtime, mtime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(root, 0755); err != nil {
+		t.Fatal(err)
+	}
+	dockerfile := filepath.Join(dir, "Dockerfile")
+	if err := ioutil.WriteFile(dockerfile, []byte("FROM scratch\nENV foo=bar\nCOPY . "+root+"/\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	digestFile := filepath.Join(dir, "digest")
+	if err := DoBuild(KanikoBuildArgs{
+		DockerfilePath: dockerfile,
+		SrcContext:     context,
+		SnapshotMode:   constants.SnapshotModeFull,
+		NoPush:         true,
+		Reproducible:   reproducible,
+		DigestFile:     digestFile,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(digestFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func Test_reproducibleBuild(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	// Build in the temp dir rather than the root of the file system
+	defer func(r, l string) {
+		rootDir, layersDir = r, l
+	}(rootDir, layersDir)
+	dir := filepath.Join(tmp, "build")
+	rootDir = filepath.Join(dir, "root")
+	layersDir = filepath.Join(tmp, "layers")
+	defer util.SetReproducibleTime(nil)
+
+	files := map[string]string{
+		"foo":     "hello",
+		"bar/baz": "goodbye",
+		"bar/bat": "bat",
+	}
+	first := time.Now().Add(-time.Hour)
+	second := time.Now()
+
+	// Without --reproducible, the mtimes of the files and the history make the digests differ
+	if buildDigest(t, dir, files, first, false) == buildDigest(t, dir, files, second, false) {
+		t.Error("Expected digests of images built at different times to differ")
+	}
+	expected := buildDigest(t, dir, files, first, true)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, buildDigest(t, dir, files, second, true))
+}
+
+func Test_cacheAfterRemoteURL(t *testing.T) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	defer func(r, l string) {
+		rootDir, layersDir = r, l
+	}(rootDir, layersDir)
+	rootDir = filepath.Join(tmp, "root")
+	layersDir = filepath.Join(tmp, "layers")
+	context := filepath.Join(tmp, "context")
+	if err := os.MkdirAll(context, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	var content string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, content)
+	}))
+	defer server.Close()
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	dockerfile := filepath.Join(tmp, "Dockerfile")
+	contents := "FROM scratch\nADD " + server.URL + "/file " + rootDir + "/file\nRUN /bin/cp " + rootDir + "/file " + rootDir + "/copy\n"
+	if err := ioutil.WriteFile(dockerfile, []byte(contents), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The RUN command after the ADD has to run again when the contents of the URL change
+	for _, content = range []string{"first", "second"} {
+		if err := os.RemoveAll(rootDir); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(rootDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := DoBuild(KanikoBuildArgs{
+			DockerfilePath: dockerfile,
+			SrcContext:     context,
+			SnapshotMode:   constants.SnapshotModeFull,
+			NoPush:         true,
+			Cache:          true,
+			CacheRepo:      registry.Host() + "/test/cache",
+			Registry:       util.RegistryOptions{InsecureRegistries: []string{registry.Host()}},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadFile(filepath.Join(rootDir, "copy"))
+		testutil.CheckErrorAndDeepEqual(t, false, err, content, string(b))
+	}
+}
+
+func Test_stepFail(t *testing.T) {
+	stages, _, err := dockerfile.Parse([]byte("FROM scratch\nRUN  make  all"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := startStep(1, 2, 3, stages[0].Commands[0])
+	err = s.fail(errors.New("exit status 2: make: *** No rule to make target 'all'"))
+	expected := "stage 1, step 2: RUN  make  all: exit status 2: make: *** No rule to make target 'all'"
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, err.Error())
+}
+
+func Test_stepFailEvent(t *testing.T) {
+	var logs bytes.Buffer
+	logrus.SetOutput(&logs)
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	defer logrus.SetOutput(os.Stderr)
+	defer logrus.SetFormatter(&logrus.TextFormatter{})
+
+	stages, _, err := dockerfile.Parse([]byte("FROM scratch\nRUN make"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := startStep(0, 1, 1, stages[0].Commands[0])
+	s.fail(errors.New("exit status 2"))
+
+	var events []string
+	var failed map[string]interface{}
+	decoder := json.NewDecoder(&logs)
+	for decoder.More() {
+		var entry map[string]interface{}
+		if err := decoder.Decode(&entry); err != nil {
+			t.Fatal(err)
+		}
+		event, _ := entry["event"].(string)
+		events = append(events, event)
+		if event == eventStepFailed {
+			failed = entry
+		}
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{eventStepStarted, eventStepFailed}, events)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, "exit status 2", failed["error"])
+	testutil.CheckErrorAndDeepEqual(t, false, nil, "RUN make", failed["command"])
+	if _, ok := failed["duration"].(float64); !ok {
+		t.Errorf("Expected the duration of the failed step, got %v", failed["duration"])
+	}
+}
@@ -0,0 +1,183 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/commands"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/dockerfile"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/containers/image/manifest"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+// Snapshots taken after commands which don't snapshot the file system
+const (
+	// SnapshotNone means the command only changes the config, so no layer is created
+	SnapshotNone = "none"
+	// SnapshotFiles means only the files the command adds or creates are snapshotted
+	SnapshotFiles = "files"
+)
+
+// Plan describes how an image would be built, without building it
+type Plan struct {
+	// Stages are the stages which would be built, which are only the ones the target stage depends on if there is one
+	Stages []StagePlan `json:"stages"`
+}
+
+// StagePlan describes how a stage would be built
+type StagePlan struct {
+	Index int    `json:"index"`
+	Name  string `json:"name,omitempty"`
+	// BaseImage is the image the stage is built from, or the index of the earlier stage it is built from
+	BaseImage string `json:"baseImage"`
+	// BaseImageDigest is the digest the base image resolves to, if it is an image in a registry
+	BaseImageDigest digest.Digest `json:"baseImageDigest,omitempty"`
+	// Saved is whether the stage is used by a later stage, and so is saved once it is built
+	Saved    bool          `json:"saved"`
+	Commands []CommandPlan `json:"commands"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// CommandPlan describes how a command would be executed
+type CommandPlan struct {
+	// Command is the command as it is written in the Dockerfile
+	Command string `json:"command"`
+	// Snapshot is SnapshotNone or SnapshotFiles, or the snapshot mode if the whole file system is snapshotted
+	Snapshot string `json:"snapshot"`
+	// Cached is whether the layer the command creates would be looked up in the layer cache
+	Cached bool `json:"cached,omitempty"`
+	// ContextFiles is the number of files ADD and COPY would use from the build context
+	ContextFiles int    `json:"contextFiles,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// DoPlan checks that the Dockerfile can be built without building it, and returns a plan of how it would be built.
+// Every command must be supported, the sources of ADD and COPY must be in the build context,
+// and base images must resolve to a digest; only their manifests and configs are downloaded.
+// The problems found are recorded in the plan, and reported by the error returned with it.
+func DoPlan(k KanikoBuildArgs) (*Plan, error) {
+	stages, buildArgs, err := parseStages(k)
+	if err != nil {
+		return nil, err
+	}
+	if err := util.LoadDockerignore(k.SrcContext); err != nil {
+		return nil, err
+	}
+	if err := util.SetRegistryOptions(k.Registry); err != nil {
+		return nil, err
+	}
+	plan := &Plan{}
+	// configs are the configs each stage ends with, which stages built from it start with
+	configs := make([]*manifest.Schema2Config, len(stages))
+	build := stagesToBuild(stages, k)
+	for index, stage := range stages {
+		if !build[index] {
+			continue
+		}
+		buildArgs.ResetStage()
+		stagePlan := StagePlan{
+			Index:     index,
+			Name:      stage.Name,
+			BaseImage: stage.BaseName,
+			Saved:     dockerfile.SaveStage(index, stages),
+			Commands:  []CommandPlan{},
+		}
+		config := &manifest.Schema2Config{}
+		if previous, err := strconv.Atoi(stage.BaseName); err == nil && previous < index {
+			*config = *configs[previous]
+			config.Env = append([]string{}, config.Env...)
+		} else if stage.BaseName != constants.NoBaseImage {
+			dgst, baseConfig, err := util.InspectImage(stage.BaseName)
+			if err != nil {
+				stagePlan.Error = fmt.Sprintf("resolving base image %s: %s", stage.BaseName, err)
+			} else {
+				stagePlan.BaseImageDigest, config = dgst, baseConfig
+			}
+		}
+		if err := resolveOnBuild(&stage, config); err != nil {
+			stagePlan.Error = fmt.Sprintf("parsing build triggers of %s: %s", stage.BaseName, err)
+		}
+		for _, cmd := range stage.Commands {
+			stagePlan.Commands = append(stagePlan.Commands, planCommand(cmd, k, config, buildArgs))
+		}
+		configs[index] = config
+		plan.Stages = append(plan.Stages, stagePlan)
+	}
+	return plan, plan.err()
+}
+
+// planCommand describes how cmd would be executed with config, checking the sources of ADD and COPY
+// ENV and ARG are executed, since they only change the config and the build args, which later commands are resolved with.
+func planCommand(cmd instructions.Command, k KanikoBuildArgs, config *manifest.Schema2Config, buildArgs *dockerfile.BuildArgs) CommandPlan {
+	command := instructionText(cmd)
+	dockerCommand, err := commands.GetCommand(cmd, k.SrcContext)
+	if err != nil {
+		return CommandPlan{Command: command, Error: err.Error()}
+	}
+	p := CommandPlan{
+		Command:  command,
+		Snapshot: SnapshotNone,
+		Cached:   k.Cache && cacheable(dockerCommand, config, buildArgs),
+	}
+	switch dockerCommand.(type) {
+	case *commands.RunCommand:
+		p.Snapshot = k.SnapshotMode
+	case *commands.AddCommand, *commands.CopyCommand, *commands.WorkdirCommand, *commands.VolumeCommand:
+		p.Snapshot = SnapshotFiles
+	case *commands.EnvCommand, *commands.ArgCommand:
+		err = dockerCommand.ExecuteCommand(config, buildArgs)
+	}
+	// Files copied from earlier stages aren't checked, since the stages aren't built
+	if c, ok := cmd.(*instructions.CopyCommand); ok && c.From != "" {
+		return p
+	}
+	if contextCommand, ok := dockerCommand.(commands.ContextCommand); ok && err == nil {
+		var files []string
+		files, err = contextCommand.FilesUsedFromContext(config, buildArgs)
+		p.ContextFiles = len(files)
+	}
+	if err != nil {
+		p.Error = err.Error()
+	}
+	return p
+}
+
+// err returns an error listing the problems found in the plan, or nil if there are none
+func (p *Plan) err() error {
+	var problems []string
+	for _, stage := range p.Stages {
+		if stage.Error != "" {
+			problems = append(problems, fmt.Sprintf("stage %d: %s", stage.Index, stage.Error))
+		}
+		for _, cmd := range stage.Commands {
+			if cmd.Error != "" {
+				problems = append(problems, fmt.Sprintf("stage %d: %s: %s", stage.Index, cmd.Command, cmd.Error))
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.Errorf("found %d problems with the Dockerfile:\n%s", len(problems), strings.Join(problems, "\n"))
+}
@@ -0,0 +1,132 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"encoding/json"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_DoPlan(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	config, err := json.Marshal(manifest.Schema2Image{
+		Schema2V1Image: manifest.Schema2V1Image{
+			Config: &manifest.Schema2Config{Env: []string{"SRC=src"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.Schema2FromComponents(manifest.Schema2Descriptor{
+		MediaType: manifest.DockerV2Schema2ConfigMediaType,
+		Size:      int64(len(config)),
+		Digest:    registry.AddBlob(config),
+	}, []manifest.Schema2Descriptor{}).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.AddManifest("test/base", "latest", m, manifest.DockerV2Schema2MediaType)
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dockerfile := `FROM ` + registry.Host() + `/test/base AS builder
+ARG DEST=/app/
+COPY $SRC/ $DEST
+RUN make
+FROM builder
+COPY $SRC/main.go /src/
+COPY --from=builder /app /app
+ADD missing.txt /
+MAINTAINER kaniko
+FROM ` + registry.Host() + `/test/missing
+`
+	if err := testutil.SetupFiles(dir, map[string]string{
+		"Dockerfile":   dockerfile,
+		"src/main.go":  "package main",
+		"src/Makefile": "all:",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer util.SetRegistryOptions(util.RegistryOptions{})
+
+	plan, err := DoPlan(KanikoBuildArgs{
+		DockerfilePath: filepath.Join(dir, "Dockerfile"),
+		SrcContext:     dir,
+		SnapshotMode:   constants.SnapshotModeFull,
+		Cache:          true,
+		Registry:       util.RegistryOptions{InsecureRegistries: []string{registry.Host()}},
+	})
+	// The missing file, the unsupported command and the missing image are all reported
+	testutil.CheckError(t, true, err)
+	if plan == nil {
+		t.Fatal("Expected a plan")
+	}
+	missingImage := plan.Stages[2].Error
+	plan.Stages[2].Error = ""
+	missingFile := plan.Stages[1].Commands[2].Error
+	plan.Stages[1].Commands[2].Error = ""
+	expected := &Plan{
+		Stages: []StagePlan{
+			{
+				Index:           0,
+				Name:            "builder",
+				BaseImage:       registry.Host() + "/test/base",
+				BaseImageDigest: digest.FromBytes(m),
+				Saved:           true,
+				Commands: []CommandPlan{
+					{Command: "ARG DEST=/app/", Snapshot: SnapshotNone},
+					// Sources are resolved with the ENV of the base image and ARGs
+					{Command: "COPY $SRC/ $DEST", Snapshot: SnapshotFiles, Cached: true, ContextFiles: 3},
+					{Command: "RUN make", Snapshot: constants.SnapshotModeFull, Cached: true},
+				},
+			},
+			{
+				Index:     1,
+				BaseImage: "0",
+				Commands: []CommandPlan{
+					// The ENV of the stage's base image is inherited from the stage it is built from
+					{Command: "COPY $SRC/main.go /src/", Snapshot: SnapshotFiles, Cached: true, ContextFiles: 1},
+					// Files copied from earlier stages aren't checked
+					{Command: "COPY --from=builder /app /app", Snapshot: SnapshotFiles, Cached: true},
+					{Command: "ADD missing.txt /", Snapshot: SnapshotFiles, Cached: true},
+					{Command: "MAINTAINER kaniko", Error: "maintainer is not a supported command"},
+				},
+			},
+			{
+				Index:     2,
+				BaseImage: registry.Host() + "/test/missing",
+				Commands:  []CommandPlan{},
+			},
+		},
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, plan)
+	if missingImage == "" || missingFile == "" {
+		t.Errorf("Expected errors for the missing image and file, got %q and %q", missingImage, missingFile)
+	}
+}
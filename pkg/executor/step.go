@@ -0,0 +1,96 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package executor
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/docker/docker/builder/dockerfile/instructions"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// The events logged for each step, in the "event" field of the structured logs
+const (
+	eventStepStarted  = "step_started"
+	eventStepFinished = "step_finished"
+	eventStepFailed   = "step_failed"
+)
+
+// step is a command being executed in a stage, which logs an event when it starts and when it finishes
+type step struct {
+	stage   int
+	number  int
+	command string
+	start   time.Time
+}
+
+// startStep logs that the command at number, counting from 1, of the stage at index is starting
+func startStep(index, number, total int, cmd instructions.Command) *step {
+	s := &step{
+		stage:   index,
+		number:  number,
+		command: instructionText(cmd),
+		start:   time.Now(),
+	}
+	s.log(eventStepStarted).Infof("Step %d/%d: %s", number, total, s.command)
+	return s
+}
+
+// finish logs that the step finished, with how long it took in seconds, the layer it created, if any,
+// and whether the layer was retrieved from the cache
+func (s *step) finish(layer *util.Layer, cached bool) {
+	entry := s.log(eventStepFinished).WithFields(logrus.Fields{
+		"duration": time.Since(s.start).Seconds(),
+		"cached":   cached,
+	})
+	if layer != nil {
+		entry = entry.WithFields(logrus.Fields{
+			"snapshotSize": layer.Size,
+			"layerDigest":  layer.Digest.String(),
+		})
+	}
+	entry.Infof("Finished step %d: %s", s.number, s.command)
+}
+
+// fail logs that the step failed, with the error and how long it took in seconds,
+// and returns err with the stage, step and command it happened in
+func (s *step) fail(err error) error {
+	s.log(eventStepFailed).WithError(err).WithField("duration", time.Since(s.start).Seconds()).
+		Infof("Step %d failed: %s", s.number, s.command)
+	return errors.Wrapf(err, "stage %d, step %d: %s", s.stage, s.number, s.command)
+}
+
+func (s *step) log(event string) *logrus.Entry {
+	return logrus.WithFields(logrus.Fields{
+		"event":   event,
+		"stage":   s.stage,
+		"step":    s.number,
+		"command": s.command,
+	})
+}
+
+// instructionText returns the instruction as it is written in the Dockerfile
+func instructionText(cmd instructions.Command) string {
+	if s, ok := cmd.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return strings.ToUpper(cmd.Name())
+}
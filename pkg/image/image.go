@@ -17,62 +17,194 @@ limitations under the License.
 package image
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 
-	"github.com/GoogleCloudPlatform/kaniko/pkg/version"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/containers/image/manifest"
 	"github.com/containers/image/types"
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
 
 	img "github.com/GoogleCloudPlatform/container-diff/pkg/image"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
 	"github.com/containers/image/copy"
-	"github.com/containers/image/docker"
+	"github.com/containers/image/oci/layout"
 	"github.com/containers/image/signature"
 	"github.com/containers/image/transports/alltransports"
 	"github.com/sirupsen/logrus"
 )
 
-// sourceImage is the image that will be modified by the executor
+// PushImage pushes the final image to each of destinations, and returns the digest of the manifest pushed to each
+func PushImage(ms *MutableSource, destinations []string) ([]digest.Digest, error) {
+	return pushImage(ms, destinations, util.NewSystemContext)
+}
 
-// InitializeSourceImage initializes the source image with the base image
-func NewSourceImage(srcImg string) (*img.MutableSource, error) {
-	if srcImg == constants.NoBaseImage {
-		return img.NewMutableSource(nil)
+// pushImage pushes the image to each of destinations, accessing each registry with the context newSystemContext returns for it
+// The image is copied in full to the first destination in each repository; the blobs are then already in the repository,
+// so only the manifest needs to be written for the other tags. If pushing to a destination fails, the remaining
+// destinations aren't pushed, and the error reports which destinations were.
+func pushImage(ms *MutableSource, destinations []string, newSystemContext func(registry string) (*types.SystemContext, error)) ([]digest.Digest, error) {
+	// Parse every destination first, so that an invalid one fails the push before anything is pushed
+	var destRefs []types.ImageReference
+	for _, destImg := range destinations {
+		destRef, err := alltransports.ParseImageName("docker://" + destImg)
+		if err != nil {
+			return nil, err
+		}
+		destRefs = append(destRefs, destRef)
 	}
-	logrus.Infof("Initializing source image %s", srcImg)
-	ref, err := docker.ParseReference("//" + srcImg)
+	// manifests are the manifests which have been pushed to each repository
+	manifests := make(map[string][]byte)
+	var digests []digest.Digest
+	for i, destRef := range destRefs {
+		repo := destRef.DockerReference().Name()
+		sys, err := newSystemContext(reference.Domain(destRef.DockerReference()))
+		if err == nil {
+			if m, ok := manifests[repo]; ok {
+				logrus.Infof("Tagging image as %s", destinations[i])
+				err = putManifest(destRef, m, sys)
+			} else {
+				logrus.Infof("Pushing image to %s", destinations[i])
+				if err = CopyImage(ms, destRef, sys); err == nil {
+					manifests[repo], err = getManifest(destRef, sys)
+				}
+			}
+		}
+		var dgst digest.Digest
+		if err == nil {
+			dgst, err = manifest.Digest(manifests[repo])
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "pushing to %s failed; pushed to %v, did not push to %v", destinations[i], destinations[:i], destinations[i+1:])
+		}
+		digests = append(digests, dgst)
+	}
+	logrus.Infof("Pushed image to %v", destinations)
+	return digests, nil
+}
+
+func getManifest(ref types.ImageReference, sys *types.SystemContext) ([]byte, error) {
+	src, err := ref.NewImageSource(sys)
 	if err != nil {
 		return nil, err
 	}
-	return img.NewMutableSource(ref)
+	defer src.Close()
+	m, _, err := src.GetManifest(nil)
+	return m, err
 }
 
-// PushImage pushes the final image
-func PushImage(ms *img.MutableSource, destImg string) error {
-	srcRef := &img.ProxyReference{
-		ImageReference: nil,
-		Src:            ms,
+func putManifest(ref types.ImageReference, m []byte, sys *types.SystemContext) error {
+	dest, err := ref.NewImageDestination(sys)
+	if err != nil {
+		return err
 	}
-	destRef, err := alltransports.ParseImageName("docker://" + destImg)
+	defer dest.Close()
+	if err := dest.PutManifest(m); err != nil {
+		return err
+	}
+	return dest.Commit()
+}
+
+// SaveStage saves the image built by the stage at index as a tarball, so that later stages can use it
+func SaveStage(ms *MutableSource, index int) error {
+	if err := os.MkdirAll(constants.KanikoIntermediateStagesDir, 0755); err != nil {
+		return err
+	}
+	path := util.StageTarballPath(index)
+	logrus.Infof("Saving stage %d to %s", index, path)
+	return writeTarball(ms, path, fmt.Sprintf("kaniko-stage-%d", index))
+}
+
+// SaveImageToTarball writes the image to a tarball at path which can be loaded with docker load,
+// tagged with destImg
+func SaveImageToTarball(ms *MutableSource, path, destImg string) error {
+	if destImg == "" {
+		return errors.Errorf("a destination is required to tag the image saved to %s", path)
+	}
+	logrus.Infof("Saving image to tarball %s", path)
+	return writeTarball(ms, path, destImg)
+}
+
+// SaveImageToOCILayout writes the image to an OCI image layout at path
+// The image is referred to by the tag of destImg, or latest if destImg is empty or has no tag.
+func SaveImageToOCILayout(ms *MutableSource, path, destImg string) error {
+	tag := "latest"
+	if destImg != "" {
+		ref, err := reference.ParseNormalizedNamed(destImg)
+		if err != nil {
+			return err
+		}
+		if tagged, ok := ref.(reference.Tagged); ok {
+			tag = tagged.Tag()
+		}
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+	destRef, err := layout.NewReference(path, tag)
 	if err != nil {
 		return err
 	}
-	policyContext, err := getPolicyContext()
+	logrus.Infof("Saving image to OCI layout %s", path)
+	return CopyImage(ms, destRef, nil)
+}
+
+func writeTarball(ms *MutableSource, path, name string) error {
+	// docker-archive refuses to overwrite an existing tarball
+	if err := os.RemoveAll(path); err != nil {
+		return err
+	}
+	destRef, err := newTarballReference(path, name)
 	if err != nil {
 		return err
 	}
-	logrus.Infof("Pushing image to %s", destImg)
+	return CopyImage(ms, destRef, nil)
+}
 
+// CopyImage copies the image to destRef, using sys to access it
+// Signatures of the base image aren't copied, since they aren't valid for the modified image.
+func CopyImage(ms *MutableSource, destRef types.ImageReference, sys *types.SystemContext) error {
+	srcRef := &img.ProxyReference{
+		ImageReference: nil,
+		Src:            &stageSource{ms},
+	}
+	policyContext, err := getPolicyContext()
+	if err != nil {
+		return err
+	}
 	opts := &copy.Options{
-		DestinationCtx: &types.SystemContext{
-			DockerRegistryUserAgent: fmt.Sprintf("kaniko/executor-%s", version.Version()),
-		},
+		RemoveSignatures: true,
+		DestinationCtx:   sys,
 	}
 	return copy.Image(policyContext, destRef, srcRef, opts)
 }
 
+// stageSource wraps a MutableSource so that it always provides a complete schema 2 manifest,
+// which the docker-archive and docker transports require; images built from scratch don't set the version or media type
+type stageSource struct {
+	*MutableSource
+}
+
+func (s *stageSource) GetManifest(instanceDigest *digest.Digest) ([]byte, string, error) {
+	b, mediaType, err := s.MutableSource.GetManifest(instanceDigest)
+	if err != nil {
+		return nil, "", err
+	}
+	var m manifest.Schema2
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, "", err
+	}
+	m.SchemaVersion = 2
+	m.MediaType = manifest.DockerV2Schema2MediaType
+	b, err = json.Marshal(m)
+	return b, mediaType, err
+}
+
 // SetEnvVariables sets environment variables as specified in the image
-func SetEnvVariables(ms *img.MutableSource) error {
+func SetEnvVariables(ms *MutableSource) error {
 	envVars := ms.Env()
 	for key, val := range envVars {
 		if err := os.Setenv(key, val); err != nil {
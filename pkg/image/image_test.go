@@ -0,0 +1,359 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package image
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/docker/archive"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/oci/layout"
+	"github.com/containers/image/transports/alltransports"
+	"github.com/containers/image/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testImage returns an image with a single layer, which is stored in dir
+func testImage(t *testing.T, dir string) *MutableSource {
+	ms, err := NewSourceImage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.Config().Env = []string{"PATH=/usr/bin"}
+	lw, err := util.NewLayerWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := tar.NewWriter(lw)
+	content := []byte("hello")
+	if err := w.WriteHeader(&tar.Header{Name: "foo", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := lw.Layer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.AppendLayer(layer)
+	return ms
+}
+
+// checkSavedImage reads the image at ref back and checks that it has the layer and config of the test image
+func checkSavedImage(t *testing.T, ref types.ImageReference) {
+	image, err := ref.NewImage(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer image.Close()
+	config, err := image.OCIConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, len(image.LayerInfos()))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"PATH=/usr/bin"}, config.Config.Env)
+}
+
+func TestSaveImageToTarball(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Paths can contain colons, which docker-archive references otherwise use to separate the tag
+	path := filepath.Join(dir, "image:latest.tar")
+
+	// Save the image twice, to make sure an existing tarball is replaced
+	for i := 0; i < 2; i++ {
+		if err := SaveImageToTarball(testImage(t, dir), path, "gcr.io/test/image:latest"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The tarball is read back from a path without a colon, which docker-archive sources need
+	if err := os.Rename(path, filepath.Join(dir, "image.tar")); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := archive.ParseReference(filepath.Join(dir, "image.tar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSavedImage(t, ref)
+}
+
+func TestSaveImageToTarballWithoutDestination(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = SaveImageToTarball(testImage(t, dir), filepath.Join(dir, "image.tar"), "")
+	testutil.CheckError(t, true, err)
+}
+
+func TestSetCreatedKeepsBaseHistory(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "base.tar")
+	if err := SaveImageToTarball(testImage(t, dir), path, "gcr.io/test/base:latest"); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := archive.ParseReference(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms, err := NewSourceImage(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ms.AppendConfigHistory("kaniko", true)
+	created := time.Unix(0, 0).UTC()
+	ms.SetCreated(created)
+
+	b, _, err := ms.GetManifest(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.Schema2FromManifest(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc, _, err := ms.GetBlob(types.BlobInfo{Digest: m.ConfigDescriptor.Digest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var cfg manifest.Schema2Image
+	if err := json.NewDecoder(rc).Decode(&cfg); err != nil {
+		t.Fatal(err)
+	}
+	// Only the image and the history entry added to the base image should have the creation time set
+	var pinned []bool
+	for _, h := range cfg.History {
+		pinned = append(pinned, h.Created.Equal(created))
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []bool{false, true}, pinned)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, true, cfg.Created.Equal(created))
+}
+
+func TestSaveImageToOCILayout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "layout")
+
+	if err := SaveImageToOCILayout(testImage(t, dir), path, "gcr.io/test/image:v1"); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := layout.NewReference(path, "v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSavedImage(t, ref)
+}
+
+func sortedTags(registry *testutil.Registry, name string) []string {
+	tags := registry.Tags(name)
+	sort.Strings(tags)
+	return tags
+}
+
+// insecureSystemContext accesses registries with the credentials found for them, over plain HTTP like the test registries
+func insecureSystemContext(registry string) (*types.SystemContext, error) {
+	sys, err := util.NewSystemContext(registry)
+	if err != nil {
+		return nil, err
+	}
+	sys.DockerInsecureSkipTLSVerify = true
+	return sys, nil
+}
+
+func TestPushImageToMultipleDestinations(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	destinations := []string{
+		registry.Host() + "/test/image:v1",
+		registry.Host() + "/test/image:latest",
+		registry.Host() + "/test/other:v1",
+	}
+	if _, err := pushImage(testImage(t, dir), destinations, insecureSystemContext); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"latest", "v1"}, sortedTags(registry, "test/image"))
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"v1"}, sortedTags(registry, "test/other"))
+
+	// Tags in the same repository should refer to the same manifest
+	var digests []string
+	for _, destination := range destinations[:2] {
+		ref, err := alltransports.ParseImageName("docker://" + destination)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m, err := getManifest(ref, &types.SystemContext{DockerInsecureSkipTLSVerify: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		digests = append(digests, string(m))
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, digests[0], digests[1])
+}
+
+func TestPushImageStopsAtFailure(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	unreachable := testutil.NewRegistry()
+	unreachable.Close()
+
+	destinations := []string{
+		registry.Host() + "/test/image:v1",
+		unreachable.Host() + "/test/image:v1",
+		registry.Host() + "/test/image:latest",
+	}
+	_, err = pushImage(testImage(t, dir), destinations, insecureSystemContext)
+	testutil.CheckError(t, true, err)
+	if err != nil && !strings.Contains(err.Error(), "pushed to ["+destinations[0]+"]") {
+		t.Errorf("Expected error to report which destinations were pushed, got: %s", err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"v1"}, sortedTags(registry, "test/image"))
+}
+
+func TestPushImageInvalidDestination(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	destinations := []string{
+		registry.Host() + "/test/image:v1",
+		"INVALID:destination:tag",
+	}
+	_, err = pushImage(testImage(t, dir), destinations, insecureSystemContext)
+	testutil.CheckError(t, true, err)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string(nil), sortedTags(registry, "test/image"))
+}
+
+func TestPushImageWithCredentials(t *testing.T) {
+	registry := testutil.NewRegistryWithAuth("user", "secret")
+	defer registry.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	destinations := []string{registry.Host() + "/test/image:v1"}
+
+	_, err = pushImage(testImage(t, dir), destinations, insecureSystemContext)
+	testutil.CheckError(t, true, err)
+
+	if err := util.SetRegistryOptions(util.RegistryOptions{
+		Credentials: map[string]types.DockerAuthConfig{
+			registry.Host(): {Username: "user", Password: "secret"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	defer util.SetRegistryOptions(util.RegistryOptions{})
+	if _, err := pushImage(testImage(t, dir), destinations, insecureSystemContext); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"v1"}, sortedTags(registry, "test/image"))
+}
+
+func TestPushImageInsecureRegistry(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	destinations := []string{registry.Host() + "/test/image:v1"}
+
+	defer util.SetRegistryOptions(util.RegistryOptions{})
+	if err := util.SetRegistryOptions(util.RegistryOptions{InsecureRegistries: []string{registry.Host()}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pushImage(testImage(t, dir), destinations, util.NewSystemContext); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"v1"}, sortedTags(registry, "test/image"))
+}
+
+func TestPushImageRegistryCertificate(t *testing.T) {
+	registry := testutil.NewTLSRegistry()
+	defer registry.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	destinations := []string{registry.Host() + "/test/image:v1"}
+
+	defer util.SetRegistryOptions(util.RegistryOptions{})
+	if err := util.SetRegistryOptions(util.RegistryOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	_, err = pushImage(testImage(t, dir), destinations, util.NewSystemContext)
+	testutil.CheckError(t, true, err)
+
+	certDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(certDir)
+	if err := ioutil.WriteFile(filepath.Join(certDir, "ca.crt"), registry.Certificate(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := util.SetRegistryOptions(util.RegistryOptions{
+		Certificates: map[string]string{registry.Host(): certDir},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pushImage(testImage(t, dir), destinations, util.NewSystemContext); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"v1"}, sortedTags(registry, "test/image"))
+}
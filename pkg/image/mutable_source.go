@@ -0,0 +1,146 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"time"
+
+	img "github.com/GoogleCloudPlatform/container-diff/pkg/image"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// MutableSource is the image being built, whose layers are stored in files rather than held in memory
+// The layers are added to the manifest and config of the underlying MutableSource whenever the manifest is requested.
+type MutableSource struct {
+	*img.MutableSource
+	layers []*util.Layer
+	// baseHistory is the number of entries in the history of the base image
+	baseHistory int
+	// created is the creation time of the image and of the history it adds to the base image, if it was set
+	created *time.Time
+	// config is the config blob referred to by the last manifest returned
+	config       []byte
+	configDigest digest.Digest
+}
+
+// NewSourceImage initializes the source image with the base image ref refers to, as returned by util.PullImage,
+// or with an empty image if ref is nil
+func NewSourceImage(ref types.ImageReference) (*MutableSource, error) {
+	ms, err := img.NewMutableSource(ref)
+	if err != nil {
+		return nil, err
+	}
+	m := &MutableSource{MutableSource: ms}
+	_, cfg, err := m.underlyingManifest(nil)
+	if err != nil {
+		return nil, err
+	}
+	m.baseHistory = len(cfg.History)
+	return m, nil
+}
+
+// AppendLayer appends layer to the image
+// The layer is read from its file when the image is pushed or saved. This hides the AppendLayer of the underlying
+// MutableSource, which records the uncompressed size of the layer in the manifest, so registries reject the push.
+func (m *MutableSource) AppendLayer(layer *util.Layer) {
+	m.layers = append(m.layers, layer)
+	m.AppendConfigHistory(constants.Author, false)
+}
+
+// SetCreated sets the creation time of the image, and of the entries it adds to the history of the base image, to t
+// The history of the base image is left as it is.
+func (m *MutableSource) SetCreated(t time.Time) {
+	m.created = &t
+}
+
+// GetManifest returns the manifest of the image, including the layers appended to it
+func (m *MutableSource) GetManifest(instanceDigest *digest.Digest) ([]byte, string, error) {
+	mfst, cfg, err := m.underlyingManifest(instanceDigest)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, layer := range m.layers {
+		mfst.LayersDescriptors = append(mfst.LayersDescriptors, manifest.Schema2Descriptor{
+			MediaType: manifest.DockerV2Schema2LayerMediaType,
+			Size:      layer.Size,
+			Digest:    layer.Digest,
+		})
+		cfg.RootFS.DiffIDs = append(cfg.RootFS.DiffIDs, layer.DiffID)
+	}
+	if m.created != nil {
+		cfg.Created = *m.created
+		for i := m.baseHistory; i < len(cfg.History); i++ {
+			cfg.History[i].Created = *m.created
+		}
+	}
+	if m.config, err = json.Marshal(cfg); err != nil {
+		return nil, "", err
+	}
+	m.configDigest = digest.FromBytes(m.config)
+	mfst.ConfigDescriptor = manifest.Schema2Descriptor{
+		MediaType: manifest.DockerV2Schema2ConfigMediaType,
+		Size:      int64(len(m.config)),
+		Digest:    m.configDigest,
+	}
+	b, err := json.Marshal(mfst)
+	return b, manifest.DockerV2Schema2MediaType, err
+}
+
+// GetBlob returns the config blob or a layer appended to the image, or otherwise a blob of the underlying MutableSource
+func (m *MutableSource) GetBlob(bi types.BlobInfo) (io.ReadCloser, int64, error) {
+	if m.config != nil && bi.Digest == m.configDigest {
+		return ioutil.NopCloser(bytes.NewReader(m.config)), int64(len(m.config)), nil
+	}
+	for _, layer := range m.layers {
+		if bi.Digest == layer.Digest {
+			rc, err := layer.Open()
+			return rc, layer.Size, err
+		}
+	}
+	return m.MutableSource.GetBlob(bi)
+}
+
+// underlyingManifest returns the manifest and config of the underlying MutableSource
+func (m *MutableSource) underlyingManifest(instanceDigest *digest.Digest) (*manifest.Schema2, *manifest.Schema2Image, error) {
+	b, _, err := m.MutableSource.GetManifest(instanceDigest)
+	if err != nil {
+		return nil, nil, err
+	}
+	var mfst manifest.Schema2
+	if err := json.Unmarshal(b, &mfst); err != nil {
+		return nil, nil, err
+	}
+	desc := mfst.ConfigDescriptor
+	rc, _, err := m.MutableSource.GetBlob(types.BlobInfo{Digest: desc.Digest, Size: desc.Size})
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+	var cfg manifest.Schema2Image
+	if err := json.NewDecoder(rc).Decode(&cfg); err != nil {
+		return nil, nil, err
+	}
+	return &mfst, &cfg, nil
+}
@@ -0,0 +1,130 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+)
+
+// BuildReport describes the image built by the executor, so that it can be referred to by digest
+type BuildReport struct {
+	// Destinations are the destinations of the image, referred to by digest
+	Destinations []string `json:"destinations"`
+	// Pushed is whether the image was pushed to its destinations
+	Pushed bool `json:"pushed"`
+	// Digest is the digest of the manifest of the image
+	Digest       digest.Digest `json:"digest"`
+	ConfigDigest digest.Digest `json:"configDigest"`
+	Layers       []LayerReport `json:"layers"`
+	// BaseImage is the image the final stage was built from, or nil if it was built from scratch
+	BaseImage *BaseImage `json:"baseImage,omitempty"`
+}
+
+// LayerReport describes a compressed layer of the image
+type LayerReport struct {
+	MediaType string        `json:"mediaType"`
+	Digest    digest.Digest `json:"digest"`
+	Size      int64         `json:"size"`
+}
+
+// BaseImage is an image a stage was built from, as it was named in the Dockerfile and by the digest it was resolved to
+type BaseImage struct {
+	Name   string        `json:"name"`
+	Digest digest.Digest `json:"digest"`
+}
+
+// NewBaseImage describes the base image name, which was pulled to ref
+func NewBaseImage(name string, ref types.ImageReference) (*BaseImage, error) {
+	m, err := getManifest(ref, nil)
+	if err != nil {
+		return nil, err
+	}
+	dgst, err := manifest.Digest(m)
+	if err != nil {
+		return nil, err
+	}
+	return &BaseImage{Name: name, Digest: dgst}, nil
+}
+
+// NewBuildReport describes the image ms, built from base, with destinations as its destinations.
+// digests are the digests returned by PushImage, or nil if the image wasn't pushed, in which case the digest
+// is that of the manifest the image would be pushed with.
+func NewBuildReport(ms *MutableSource, base *BaseImage, destinations []string, digests []digest.Digest) (*BuildReport, error) {
+	m, _, err := (&stageSource{ms}).GetManifest(nil)
+	if err != nil {
+		return nil, err
+	}
+	schema2, err := manifest.Schema2FromManifest(m)
+	if err != nil {
+		return nil, err
+	}
+	report := &BuildReport{
+		Destinations: []string{},
+		Pushed:       digests != nil,
+		Digest:       digest.FromBytes(m),
+		ConfigDigest: schema2.ConfigDescriptor.Digest,
+		Layers:       []LayerReport{},
+		BaseImage:    base,
+	}
+	for _, layer := range schema2.LayersDescriptors {
+		report.Layers = append(report.Layers, LayerReport{MediaType: layer.MediaType, Digest: layer.Digest, Size: layer.Size})
+	}
+	for i, destination := range destinations {
+		ref, err := reference.ParseNormalizedNamed(destination)
+		if err != nil {
+			return nil, err
+		}
+		dgst := report.Digest
+		if digests != nil {
+			dgst = digests[i]
+		}
+		report.Destinations = append(report.Destinations, ref.Name()+"@"+dgst.String())
+	}
+	if len(digests) > 0 {
+		report.Digest = digests[0]
+	}
+	return report, nil
+}
+
+// WriteDigest writes the digest of the image to path
+func (r *BuildReport) WriteDigest(path string) error {
+	return ioutil.WriteFile(path, []byte(r.Digest), 0644)
+}
+
+// WriteImageNamesWithDigest writes each destination of the image, referred to by digest, to path on a line of its own
+func (r *BuildReport) WriteImageNamesWithDigest(path string) error {
+	var names []byte
+	for _, destination := range r.Destinations {
+		names = append(names, destination+"\n"...)
+	}
+	return ioutil.WriteFile(path, names, 0644)
+}
+
+// WriteJSON writes the report to path as JSON
+func (r *BuildReport) WriteJSON(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
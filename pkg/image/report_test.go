@@ -0,0 +1,97 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"encoding/json"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/transports/alltransports"
+	"github.com/containers/image/types"
+	"github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBuildReport(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ms := testImage(t, dir)
+	destinations := []string{registry.Host() + "/test/image:v1", registry.Host() + "/test/image:latest"}
+	base := &BaseImage{Name: "debian:stable", Digest: digest.FromString("base")}
+
+	// The digest of an image which isn't pushed is the digest it is pushed with
+	unpushed, err := NewBuildReport(ms, base, destinations, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digests, err := pushImage(ms, destinations, insecureSystemContext)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report, err := NewBuildReport(ms, base, destinations, digests)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref, err := alltransports.ParseImageName("docker://" + destinations[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := getManifest(ref, &types.SystemContext{DockerInsecureSkipTLSVerify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushed := digest.FromBytes(m)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, pushed, report.Digest)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, pushed, unpushed.Digest)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, false, unpushed.Pushed)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, true, report.Pushed)
+	expectedDestinations := []string{registry.Host() + "/test/image@" + pushed.String(), registry.Host() + "/test/image@" + pushed.String()}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expectedDestinations, report.Destinations)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 1, len(report.Layers))
+
+	digestFile := filepath.Join(dir, "digest")
+	namesFile := filepath.Join(dir, "names")
+	reportFile := filepath.Join(dir, "report.json")
+	if err := report.WriteDigest(digestFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := report.WriteImageNamesWithDigest(namesFile); err != nil {
+		t.Fatal(err)
+	}
+	if err := report.WriteJSON(reportFile); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(digestFile)
+	testutil.CheckErrorAndDeepEqual(t, false, err, pushed.String(), string(content))
+	content, err = ioutil.ReadFile(namesFile)
+	testutil.CheckErrorAndDeepEqual(t, false, err, expectedDestinations[0]+"\n"+expectedDestinations[1]+"\n", string(content))
+	content, err = ioutil.ReadFile(reportFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written BuildReport
+	err = json.Unmarshal(content, &written)
+	testutil.CheckErrorAndDeepEqual(t, false, err, *report, written)
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package image
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/containers/image/docker/archive"
+	"github.com/containers/image/docker/reference"
+	"github.com/containers/image/docker/tarfile"
+	"github.com/containers/image/types"
+	"github.com/pkg/errors"
+)
+
+// tarballReference is a docker-archive destination, which writes a tarball that can be loaded with docker load.
+// archive.ParseReference splits its argument at the first colon into the path and the tag, so it can't refer to
+// paths which contain a colon; the vendored archive package has no other way to create a reference.
+type tarballReference struct {
+	path string
+	tag  reference.NamedTagged
+}
+
+// newTarballReference returns a reference to a tarball at path, containing the image tagged with name
+func newTarballReference(path, name string) (*tarballReference, error) {
+	ref, err := reference.ParseNormalizedNamed(name)
+	if err != nil {
+		return nil, err
+	}
+	tagged, ok := reference.TagNameOnly(ref).(reference.NamedTagged)
+	if !ok {
+		return nil, errors.Errorf("tarballs can only be tagged with a tag, not %s", name)
+	}
+	return &tarballReference{path: path, tag: tagged}, nil
+}
+
+func (r *tarballReference) Transport() types.ImageTransport {
+	return archive.Transport
+}
+
+func (r *tarballReference) StringWithinTransport() string {
+	return fmt.Sprintf("%s:%s", r.path, r.tag.String())
+}
+
+func (r *tarballReference) DockerReference() reference.Named {
+	return r.tag
+}
+
+func (r *tarballReference) PolicyConfigurationIdentity() string {
+	return ""
+}
+
+func (r *tarballReference) PolicyConfigurationNamespaces() []string {
+	return []string{}
+}
+
+func (r *tarballReference) NewImage(ctx *types.SystemContext) (types.ImageCloser, error) {
+	return nil, errors.New("tarball references can only be written to")
+}
+
+func (r *tarballReference) NewImageSource(ctx *types.SystemContext) (types.ImageSource, error) {
+	return nil, errors.New("tarball references can only be written to")
+}
+
+// NewImageDestination creates the tarball, which mustn't already exist
+func (r *tarballReference) NewImageDestination(ctx *types.SystemContext) (types.ImageDestination, error) {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &tarballDestination{
+		Destination: tarfile.NewDestination(f, r.tag),
+		ref:         r,
+		f:           f,
+	}, nil
+}
+
+func (r *tarballReference) DeleteImage(ctx *types.SystemContext) error {
+	return errors.New("deleting tarballs isn't supported")
+}
+
+// tarballDestination writes the image to the tarball
+type tarballDestination struct {
+	*tarfile.Destination
+	ref *tarballReference
+	f   *os.File
+}
+
+func (d *tarballDestination) Reference() types.ImageReference {
+	return d.ref
+}
+
+func (d *tarballDestination) Close() error {
+	return d.f.Close()
+}
@@ -16,25 +16,76 @@ limitations under the License.
 
 package snapshot
 
+import (
+	"os"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+// hashWorkers is the number of files hashed at once
+var hashWorkers = runtime.NumCPU()
+
 type LayeredMap struct {
-	layers []map[string]string
-	hasher func(string) (string, error)
+	layers    []map[string]string
+	whiteouts []map[string]struct{}
+	hasher    func(string) (string, error)
+	// hashes are the most recent hash of each file, and the stat of the file it was computed from
+	hashes map[string]statHash
+}
+
+// fileStat is the metadata of a file which changes whenever the file does, since writing to a file,
+// or changing its metadata, updates its ctime
+type fileStat struct {
+	ino   uint64
+	mode  os.FileMode
+	size  int64
+	mtime int64
+	ctime int64
+}
+
+type statHash struct {
+	stat fileStat
+	hash string
 }
 
 func NewLayeredMap(h func(string) (string, error)) *LayeredMap {
 	l := LayeredMap{
 		hasher: h,
+		hashes: map[string]statHash{},
 	}
 	l.layers = []map[string]string{}
+	l.whiteouts = []map[string]struct{}{}
 	return &l
 }
 
+func newFileStat(fi os.FileInfo) (fileStat, bool) {
+	if fi == nil {
+		return fileStat{}, false
+	}
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fileStat{}, false
+	}
+	return fileStat{
+		ino:   stat.Ino,
+		mode:  fi.Mode(),
+		size:  fi.Size(),
+		mtime: fi.ModTime().UnixNano(),
+		ctime: stat.Ctim.Nano(),
+	}, true
+}
+
 func (l *LayeredMap) Snapshot() {
 	l.layers = append(l.layers, map[string]string{})
+	l.whiteouts = append(l.whiteouts, map[string]struct{}{})
 }
 
 func (l *LayeredMap) Get(s string) (string, bool) {
 	for i := len(l.layers) - 1; i >= 0; i-- {
+		if _, ok := l.whiteouts[i][s]; ok {
+			return "", false
+		}
 		if v, ok := l.layers[i][s]; ok {
 			return v, ok
 		}
@@ -42,15 +93,105 @@ func (l *LayeredMap) Get(s string) (string, bool) {
 	return "", false
 }
 
+// Paths returns every path which exists as of the most recent layer,
+// taking whiteouts in later layers into account
+func (l *LayeredMap) Paths() map[string]struct{} {
+	paths := map[string]struct{}{}
+	for i := range l.layers {
+		for p := range l.whiteouts[i] {
+			delete(paths, p)
+		}
+		for p := range l.layers[i] {
+			paths[p] = struct{}{}
+		}
+	}
+	return paths
+}
+
+// AddWhiteout records that the path s was deleted in the current layer
+func (l *LayeredMap) AddWhiteout(s string) {
+	current := len(l.layers) - 1
+	delete(l.layers[current], s)
+	l.whiteouts[current][s] = struct{}{}
+}
+
 func (l *LayeredMap) MaybeAdd(s string) (bool, error) {
-	oldV, ok := l.Get(s)
 	newV, err := l.hasher(s)
 	if err != nil {
 		return false, err
 	}
+	return l.maybeAddHash(s, newV), nil
+}
+
+// MaybeAddFiles adds each of paths whose hash has changed to the current layer, and returns the paths which were added
+// infos are the FileInfos of the paths. The files are hashed concurrently, and a file isn't hashed again if its inode,
+// mode, size, mtime and ctime haven't changed since it was last hashed.
+func (l *LayeredMap) MaybeAddFiles(paths []string, infos map[string]os.FileInfo) ([]string, error) {
+	hashes, err := l.hashFiles(paths, infos)
+	if err != nil {
+		return nil, err
+	}
+	var added []string
+	for i, p := range paths {
+		if l.maybeAddHash(p, hashes[i]) {
+			added = append(added, p)
+		}
+	}
+	return added, nil
+}
+
+func (l *LayeredMap) maybeAddHash(s, newV string) bool {
+	oldV, ok := l.Get(s)
 	if ok && newV == oldV {
-		return false, nil
+		return false
+	}
+	current := len(l.layers) - 1
+	delete(l.whiteouts[current], s)
+	l.layers[current][s] = newV
+	return true
+}
+
+// hashFiles returns the hashes of paths, reusing the previous hash of files which haven't changed
+// and hashing the rest with a pool of hashWorkers workers
+func (l *LayeredMap) hashFiles(paths []string, infos map[string]os.FileInfo) ([]string, error) {
+	hashes := make([]string, len(paths))
+	stats := make([]fileStat, len(paths))
+	hasStat := make([]bool, len(paths))
+	var toHash []int
+	for i, p := range paths {
+		stats[i], hasStat[i] = newFileStat(infos[p])
+		if prev, ok := l.hashes[p]; ok && hasStat[i] && prev.stat == stats[i] {
+			hashes[i] = prev.hash
+			continue
+		}
+		toHash = append(toHash, i)
+	}
+
+	indexes := make(chan int)
+	errs := make([]error, len(paths))
+	var wg sync.WaitGroup
+	for w := 0; w < hashWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				hashes[i], errs[i] = l.hasher(paths[i])
+			}
+		}()
+	}
+	for _, i := range toHash {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	for _, i := range toHash {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		if hasStat[i] {
+			l.hashes[paths[i]] = statHash{stat: stats[i], hash: hashes[i]}
+		}
 	}
-	l.layers[len(l.layers)-1][s] = newV
-	return true, nil
+	return hashes, nil
 }
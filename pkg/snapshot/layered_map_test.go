@@ -0,0 +1,211 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package snapshot
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingHasher wraps hasher, counting the number of times each file is hashed
+type countingHasher struct {
+	sync.Mutex
+	hasher func(string) (string, error)
+	counts map[string]int
+}
+
+func (c *countingHasher) hash(p string) (string, error) {
+	c.Lock()
+	c.counts[p]++
+	c.Unlock()
+	return c.hasher(p)
+}
+
+func statFiles(t testing.TB, dir string) ([]string, map[string]os.FileInfo) {
+	var paths []string
+	infos := map[string]os.FileInfo{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		paths = append(paths, path)
+		infos[path] = info
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return paths, infos
+}
+
+func TestMaybeAddFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := testutil.SetupFiles(dir, map[string]string{"foo": "foo", "bar/baz": "baz"}); err != nil {
+		t.Fatal(err)
+	}
+	hasher := &countingHasher{hasher: util.Hasher(), counts: map[string]int{}}
+	l := NewLayeredMap(hasher.hash)
+
+	l.Snapshot()
+	paths, infos := statFiles(t, dir)
+	added, err := l.MaybeAddFiles(paths, infos)
+	testutil.CheckErrorAndDeepEqual(t, false, err, paths, added)
+
+	// Change the contents of foo, keeping its size and mtime
+	foo := filepath.Join(dir, "foo")
+	info := infos[foo]
+	if err := ioutil.WriteFile(foo, []byte("oof"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(foo, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	l.Snapshot()
+	paths, infos = statFiles(t, dir)
+	added, err = l.MaybeAddFiles(paths, infos)
+	testutil.CheckErrorAndDeepEqual(t, false, err, []string{foo}, added)
+	// Only foo should have been hashed again, since its ctime changed
+	for _, p := range paths {
+		expected := 1
+		if p == foo {
+			expected = 2
+		}
+		if hasher.counts[p] != expected {
+			t.Errorf("Expected %s to be hashed %d times, was hashed %d times", p, expected, hasher.counts[p])
+		}
+	}
+}
+
+// setUpBenchmarkDir creates a directory of files with a total size of about 64MB
+func setUpBenchmarkDir(b *testing.B) string {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	content := make([]byte, 64*1024)
+	for i := range content {
+		content[i] = byte(i)
+	}
+	files := map[string]string{}
+	for i := 0; i < 1024; i++ {
+		files[fmt.Sprintf("dir%d/file%d", i%32, i)] = string(content)
+	}
+	if err := testutil.SetupFiles(dir, files); err != nil {
+		b.Fatal(err)
+	}
+	return dir
+}
+
+// md5Hasher is the hasher snapshots used before contents were hashed with CRC-32C, for comparison
+func md5Hasher(p string) (string, error) {
+	h := md5.New()
+	fi, err := os.Lstat(p)
+	if err != nil {
+		return "", err
+	}
+	h.Write([]byte(fi.Mode().String()))
+	h.Write([]byte(fi.ModTime().String()))
+	if fi.Mode().IsRegular() {
+		f, err := os.Open(p)
+		if err != nil {
+			return "", err
+		}
+		defer f.Close()
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func benchmarkMaybeAddFiles(b *testing.B, hasher func(string) (string, error), workers int, unchanged bool) {
+	dir := setUpBenchmarkDir(b)
+	defer os.RemoveAll(dir)
+	defer func(w int) { hashWorkers = w }(hashWorkers)
+	hashWorkers = workers
+
+	paths, infos := statFiles(b, dir)
+	l := NewLayeredMap(hasher)
+	if unchanged {
+		l.Snapshot()
+		if _, err := l.MaybeAddFiles(paths, infos); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if !unchanged {
+			l = NewLayeredMap(hasher)
+		}
+		l.Snapshot()
+		if _, err := l.MaybeAddFiles(paths, infos); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMaybeAddFilesMD5Serial(b *testing.B) {
+	benchmarkMaybeAddFiles(b, md5Hasher, 1, false)
+}
+
+func BenchmarkMaybeAddFilesSerial(b *testing.B) {
+	benchmarkMaybeAddFiles(b, util.Hasher(), 1, false)
+}
+
+func BenchmarkMaybeAddFilesParallel(b *testing.B) {
+	benchmarkMaybeAddFiles(b, util.Hasher(), hashWorkers, false)
+}
+
+func BenchmarkMaybeAddFilesUnchanged(b *testing.B) {
+	benchmarkMaybeAddFiles(b, util.Hasher(), hashWorkers, true)
+}
+
+func BenchmarkTakeSnapshot(b *testing.B) {
+	dir := setUpBenchmarkDir(b)
+	defer os.RemoveAll(dir)
+	layersDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(layersDir)
+	snapshotter := NewSnapshotter(NewLayeredMap(util.Hasher()), dir, layersDir)
+	if err := snapshotter.Init(); err != nil {
+		b.Fatal(err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		// Touch one file, so that each snapshot contains a layer
+		now := time.Now()
+		if err := os.Chtimes(filepath.Join(dir, "dir0/file0"), now, now); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := snapshotter.TakeSnapshot(nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -18,7 +18,6 @@ package snapshot
 
 import (
 	"archive/tar"
-	"bytes"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/sirupsen/logrus"
 
@@ -26,17 +25,19 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 )
 
 // Snapshotter holds the root directory from which to take snapshots, and a list of snapshots taken
 type Snapshotter struct {
 	l         *LayeredMap
 	directory string
+	layersDir string
 }
 
-// NewSnapshotter creates a new snapshotter rooted at d
-func NewSnapshotter(l *LayeredMap, d string) *Snapshotter {
-	return &Snapshotter{l: l, directory: d}
+// NewSnapshotter creates a new snapshotter rooted at d, which writes the layers it creates to layersDir
+func NewSnapshotter(l *LayeredMap, d, layersDir string) *Snapshotter {
+	return &Snapshotter{l: l, directory: d, layersDir: layersDir}
 }
 
 // Init initializes a new snapshotter
@@ -47,83 +48,187 @@ func (s *Snapshotter) Init() error {
 	return nil
 }
 
+// SkipChanges records the changes made to the filesystem since the last snapshot without creating a layer of them,
+// so that they aren't added to the next layer. Used when the changes are already in a layer, such as a cached one.
+func (s *Snapshotter) SkipChanges() error {
+	_, err := s.snapShotFS(ioutil.Discard)
+	return err
+}
+
 // TakeSnapshot takes a snapshot of the filesystem, avoiding directories in the whitelist, and creates
-// a tarball of the changed files. Return contents of the tarball, and whether or not any files were changed
-func (s *Snapshotter) TakeSnapshot(files []string) ([]byte, error) {
+// a layer of the changed files. Returns the layer, or nil if no files were changed
+func (s *Snapshotter) TakeSnapshot(files []string) (*util.Layer, error) {
 	if files != nil {
 		return s.TakeSnapshotOfFiles(files)
 	}
 	logrus.Info("Taking snapshot of full filesystem...")
-	buf := bytes.NewBuffer([]byte{})
-	filesAdded, err := s.snapShotFS(buf)
-	if err != nil {
-		return nil, err
-	}
-	contents, err := ioutil.ReadAll(buf)
-	if err != nil {
-		return nil, err
-	}
-	if !filesAdded {
-		return nil, nil
-	}
-	return contents, err
+	return s.writeLayer(s.snapShotFS)
 }
 
 // TakeSnapshotOfFiles takes a snapshot of specific files
-// Used for ADD/COPY commands, when we know which files have changed
-func (s *Snapshotter) TakeSnapshotOfFiles(files []string) ([]byte, error) {
+// Used for ADD/COPY commands, and RUN commands which were traced, when we know which files have changed.
+// Files which no longer exist were deleted, so whiteouts are added for them and anything which was beneath them.
+func (s *Snapshotter) TakeSnapshotOfFiles(files []string) (*util.Layer, error) {
 	logrus.Infof("Taking snapshot of files %v...", files)
 	s.l.Snapshot()
 	if len(files) == 0 {
 		logrus.Info("No files changed in this command, skipping snapshotting.")
 		return nil, nil
 	}
-	buf := bytes.NewBuffer([]byte{})
-	w := tar.NewWriter(buf)
-	defer w.Close()
+	// Add the files in a consistent order, so that the layer doesn't depend on the order the command listed them in
+	files = append([]string{}, files...)
+	sort.Strings(files)
+	var paths []string
+	infos := map[string]os.FileInfo{}
+	deleted := map[string]struct{}{}
 	for _, file := range files {
+		if util.PathInWhitelist(file, s.directory) {
+			logrus.Debugf("Not adding %s to layer, as it is whitelisted", file)
+			continue
+		}
 		info, err := os.Lstat(file)
+		if os.IsNotExist(err) {
+			deleted[file] = struct{}{}
+			continue
+		}
 		if err != nil {
 			return nil, err
 		}
-		if util.PathInWhitelist(file, s.directory) {
-			logrus.Debugf("Not adding %s to layer, as it is whitelisted", file)
-			continue
+		paths = append(paths, file)
+		infos[file] = info
+	}
+	return s.writeLayer(func(f io.Writer) (bool, error) {
+		w := tar.NewWriter(f)
+		defer w.Close()
+		// Whiteouts should come before the rest of the layer contents
+		filesAdded := false
+		if len(deleted) > 0 {
+			existingPaths := s.l.Paths()
+			var err error
+			filesAdded, err = s.addWhiteouts(deletedBeneath(existingPaths, deleted), w)
+			if err != nil {
+				return false, err
+			}
 		}
 		// Only add to the tar if we add it to the layeredmap.
-		maybeAdd, err := s.l.MaybeAdd(file)
+		added, err := s.l.MaybeAddFiles(paths, infos)
 		if err != nil {
-			return nil, err
+			return false, err
 		}
-		if maybeAdd {
-			util.AddToTar(file, info, w)
+		for _, file := range added {
+			if err := util.AddToTar(file, infos[file], w); err != nil {
+				return false, err
+			}
+		}
+		return filesAdded || len(added) > 0, nil
+	})
+}
+
+// deletedBeneath returns the existing paths which are, or are beneath, one of the deleted paths
+func deletedBeneath(existingPaths, deleted map[string]struct{}) map[string]struct{} {
+	deletedPaths := map[string]struct{}{}
+	for p := range existingPaths {
+		for dir := p; ; dir = filepath.Dir(dir) {
+			if _, ok := deleted[dir]; ok {
+				deletedPaths[p] = struct{}{}
+				break
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
 		}
 	}
-	return ioutil.ReadAll(buf)
+	return deletedPaths
+}
+
+// writeLayer streams the tarball written by snapshot to a new layer in the layers directory,
+// discarding the layer if snapshot didn't add any files
+func (s *Snapshotter) writeLayer(snapshot func(io.Writer) (bool, error)) (*util.Layer, error) {
+	lw, err := util.NewLayerWriter(s.layersDir)
+	if err != nil {
+		return nil, err
+	}
+	filesAdded, err := snapshot(lw)
+	if err != nil || !filesAdded {
+		if discardErr := lw.Discard(); err == nil {
+			err = discardErr
+		}
+		return nil, err
+	}
+	return lw.Layer()
 }
 
 func (s *Snapshotter) snapShotFS(f io.Writer) (bool, error) {
+	// Any path which existed before this snapshot but isn't found during the walk has been deleted
+	existingPaths := s.l.Paths()
+	deletedPaths := make(map[string]struct{}, len(existingPaths))
+	for p := range existingPaths {
+		deletedPaths[p] = struct{}{}
+	}
 	s.l.Snapshot()
-	filesAdded := false
 	w := tar.NewWriter(f)
 	defer w.Close()
 
+	var paths []string
+	infos := map[string]os.FileInfo{}
 	err := filepath.Walk(s.directory, func(path string, info os.FileInfo, err error) error {
 		if util.PathInWhitelist(path, s.directory) {
 			logrus.Debugf("Not adding %s to layer, as it's whitelisted", path)
 			return nil
 		}
+		delete(deletedPaths, path)
+		paths = append(paths, path)
+		infos[path] = info
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
 
-		// Only add to the tar if we add it to the layeredmap.
-		maybeAdd, err := s.l.MaybeAdd(path)
-		if err != nil {
-			return err
+	// Whiteouts should come before the rest of the layer contents
+	filesAdded, err := s.addWhiteouts(deletedPaths, w)
+	if err != nil {
+		return false, err
+	}
+	// Only add to the tar if we add it to the layeredmap.
+	added, err := s.l.MaybeAddFiles(paths, infos)
+	if err != nil {
+		return false, err
+	}
+	for _, path := range added {
+		filesAdded = true
+		if err := util.AddToTar(path, infos[path], w); err != nil {
+			return false, err
 		}
-		if maybeAdd {
-			filesAdded = true
-			return util.AddToTar(path, info, w)
+	}
+	return filesAdded, nil
+}
+
+// addWhiteouts records every deleted path in the layered map, and adds whiteouts for them to the tar.
+// Only the topmost deleted path needs a whiteout, since removing a directory removes everything beneath it.
+// Directories whose contents were all deleted get a whiteout for each of them rather than an opaque whiteout,
+// which the extractor kaniko uses for its own layers doesn't understand.
+func (s *Snapshotter) addWhiteouts(deletedPaths map[string]struct{}, w *tar.Writer) (bool, error) {
+	var deleted []string
+	for p := range deletedPaths {
+		if util.PathInWhitelist(p, s.directory) {
+			continue
 		}
-		return nil
-	})
-	return filesAdded, err
+		deleted = append(deleted, p)
+	}
+	if len(deleted) == 0 {
+		return false, nil
+	}
+	sort.Strings(deleted)
+	for _, p := range deleted {
+		s.l.AddWhiteout(p)
+		if _, parentDeleted := deletedPaths[filepath.Dir(p)]; parentDeleted {
+			continue
+		}
+		logrus.Debugf("%s was deleted, adding whiteout to layer", p)
+		if err := util.AddWhiteoutToTar(p, w); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
 }
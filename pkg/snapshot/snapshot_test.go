@@ -18,6 +18,7 @@ package snapshot
 import (
 	"archive/tar"
 	"bytes"
+	pkgutil "github.com/GoogleCloudPlatform/container-diff/pkg/util"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/util"
 	"github.com/GoogleCloudPlatform/kaniko/testutil"
 	"github.com/pkg/errors"
@@ -25,6 +26,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"testing"
 )
 
@@ -45,15 +47,15 @@ func TestSnapshotFileChange(t *testing.T) {
 		t.Fatalf("Error setting up fs: %s", err)
 	}
 	// Take another snapshot
-	contents, err := snapshotter.TakeSnapshot(nil)
+	layer, err := snapshotter.TakeSnapshot(nil)
 	if err != nil {
 		t.Fatalf("Error taking snapshot of fs: %s", err)
 	}
-	if contents == nil {
+	if layer == nil {
 		t.Fatal("No files added to snapshot.")
 	}
 	// Check contents of the snapshot, make sure contents is equivalent to snapshotFiles
-	reader := bytes.NewReader(contents)
+	reader := bytes.NewReader(readLayer(t, layer))
 	tr := tar.NewReader(reader)
 	fooPath := filepath.Join(testDir, "foo")
 	batPath := filepath.Join(testDir, "bar/bat")
@@ -93,15 +95,15 @@ func TestSnapshotChangePermissions(t *testing.T) {
 		t.Fatalf("Error changing permissions on %s: %v", batPath, err)
 	}
 	// Take another snapshot
-	contents, err := snapshotter.TakeSnapshot(nil)
+	layer, err := snapshotter.TakeSnapshot(nil)
 	if err != nil {
 		t.Fatalf("Error taking snapshot of fs: %s", err)
 	}
-	if contents == nil {
+	if layer == nil {
 		t.Fatal("No files added to snapshot.")
 	}
 	// Check contents of the snapshot, make sure contents is equivalent to snapshotFiles
-	reader := bytes.NewReader(contents)
+	reader := bytes.NewReader(readLayer(t, layer))
 	tr := tar.NewReader(reader)
 	snapshotFiles := map[string]string{
 		batPath: "baz2",
@@ -144,7 +146,7 @@ func TestSnapshotFiles(t *testing.T) {
 		filepath.Join(testDir, "foo"),
 		filepath.Join(testDir, "kaniko/file"),
 	}
-	contents, err := snapshotter.TakeSnapshot(filesToSnapshot)
+	layer, err := snapshotter.TakeSnapshot(filesToSnapshot)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -152,7 +154,7 @@ func TestSnapshotFiles(t *testing.T) {
 		filepath.Join(testDir, "foo"): "newbaz1",
 	}
 	// Check contents of the snapshot, make sure contents is equivalent to snapshotFiles
-	reader := bytes.NewReader(contents)
+	reader := bytes.NewReader(readLayer(t, layer))
 	tr := tar.NewReader(reader)
 	numFiles := 0
 	for {
@@ -181,16 +183,260 @@ func TestEmptySnapshot(t *testing.T) {
 		t.Fatal(err)
 	}
 	// Take snapshot with no changes
-	contents, err := snapshotter.TakeSnapshot(nil)
+	layer, err := snapshotter.TakeSnapshot(nil)
 	if err != nil {
 		t.Fatalf("Error taking snapshot of fs: %s", err)
 	}
-	// Since we took a snapshot with no changes, contents should be nil
-	if contents != nil {
+	// Since we took a snapshot with no changes, layer should be nil
+	if layer != nil {
 		t.Fatal("Files added even though no changes to file system were made.")
 	}
 }
 
+func TestSkipChanges(t *testing.T) {
+	testDir, snapshotter, err := setUpTestDir()
+	defer os.RemoveAll(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(testDir, "foo"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(testDir, "bar", "bat")); err != nil {
+		t.Fatal(err)
+	}
+	if err := snapshotter.SkipChanges(); err != nil {
+		t.Fatal(err)
+	}
+	// The skipped changes shouldn't be added to the next layer
+	layer, err := snapshotter.TakeSnapshot(nil)
+	testutil.CheckErrorAndDeepEqual(t, false, err, (*util.Layer)(nil), layer)
+}
+
+func TestSnapshotFileDeletion(t *testing.T) {
+	testDir, snapshotter, err := setUpTestDir()
+	defer os.RemoveAll(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(filepath.Join(testDir, "foo")); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := snapshotter.TakeSnapshot(nil)
+	if err != nil {
+		t.Fatalf("Error taking snapshot of fs: %s", err)
+	}
+	expectedEntries := []string{
+		testDir,
+		filepath.Join(testDir, ".wh.foo"),
+	}
+	actualEntries, err := tarEntries(readLayer(t, layer))
+	testutil.CheckErrorAndDeepEqual(t, false, err, expectedEntries, actualEntries)
+
+	// The deleted file shouldn't be whited out again in the next snapshot
+	layer, err = snapshotter.TakeSnapshot(nil)
+	testutil.CheckErrorAndDeepEqual(t, false, err, (*util.Layer)(nil), layer)
+}
+
+func TestSnapshotDirectoryDeletion(t *testing.T) {
+	testDir, snapshotter, err := setUpTestDir()
+	defer os.RemoveAll(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(testDir, "bar")); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := snapshotter.TakeSnapshot(nil)
+	if err != nil {
+		t.Fatalf("Error taking snapshot of fs: %s", err)
+	}
+	// Only the directory itself should be whited out, not the files within it
+	expectedEntries := []string{
+		testDir,
+		filepath.Join(testDir, ".wh.bar"),
+	}
+	actualEntries, err := tarEntries(readLayer(t, layer))
+	testutil.CheckErrorAndDeepEqual(t, false, err, expectedEntries, actualEntries)
+}
+
+func TestSnapshotSymlinkDeletion(t *testing.T) {
+	testDir, snapshotter, err := setUpTestDir()
+	defer os.RemoveAll(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	linkPath := filepath.Join(testDir, "bar/link")
+	if err := os.Symlink(filepath.Join(testDir, "foo"), linkPath); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := snapshotter.TakeSnapshot(nil); err != nil {
+		t.Fatalf("Error taking snapshot of fs: %s", err)
+	}
+	if err := os.Remove(linkPath); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := snapshotter.TakeSnapshot(nil)
+	if err != nil {
+		t.Fatalf("Error taking snapshot of fs: %s", err)
+	}
+	// The link target should be left alone
+	expectedEntries := []string{
+		filepath.Join(testDir, "bar"),
+		filepath.Join(testDir, "bar/.wh.link"),
+	}
+	actualEntries, err := tarEntries(readLayer(t, layer))
+	testutil.CheckErrorAndDeepEqual(t, false, err, expectedEntries, actualEntries)
+}
+
+func TestSnapshotReplacedDirectory(t *testing.T) {
+	testDir, snapshotter, err := setUpTestDir()
+	defer os.RemoveAll(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(testDir, "bar")); err != nil {
+		t.Fatal(err)
+	}
+	newFiles := map[string]string{
+		"bar/baz": "baz",
+	}
+	if err := testutil.SetupFiles(testDir, newFiles); err != nil {
+		t.Fatalf("Error setting up fs: %s", err)
+	}
+	layer, err := snapshotter.TakeSnapshot(nil)
+	if err != nil {
+		t.Fatalf("Error taking snapshot of fs: %s", err)
+	}
+	expectedEntries := []string{
+		testDir,
+		filepath.Join(testDir, "bar"),
+		filepath.Join(testDir, "bar/.wh.bat"),
+		filepath.Join(testDir, "bar/baz"),
+	}
+	actualEntries, err := tarEntries(readLayer(t, layer))
+	testutil.CheckErrorAndDeepEqual(t, false, err, expectedEntries, actualEntries)
+}
+
+func TestSnapshotEmptiedDirectoryExtraction(t *testing.T) {
+	testDir, snapshotter, err := setUpTestDir()
+	defer os.RemoveAll(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The lower layers, as kaniko would have extracted them
+	extractDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(extractDir)
+	if err := testutil.SetupFiles(filepath.Join(extractDir, testDir), map[string]string{
+		"foo":     "baz1",
+		"bar/bat": "baz2",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// Empty the directory, as RUN rm -rf /dir/* would
+	if err := os.Remove(filepath.Join(testDir, "bar/bat")); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := snapshotter.TakeSnapshot(nil)
+	if err != nil {
+		t.Fatalf("Error taking snapshot of fs: %s", err)
+	}
+	if err := pkgutil.UnTar(bytes.NewReader(readLayer(t, layer)), extractDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Lstat(filepath.Join(extractDir, testDir, "bar/bat")); !os.IsNotExist(err) {
+		t.Errorf("Expected deleted file to be removed when the layer is extracted, got %v", err)
+	}
+	if _, err := os.Lstat(filepath.Join(extractDir, testDir, "bar")); err != nil {
+		t.Errorf("Expected emptied directory to remain when the layer is extracted, got %v", err)
+	}
+}
+
+func TestSnapshotFilesDeletion(t *testing.T) {
+	testDir, snapshotter, err := setUpTestDir()
+	defer os.RemoveAll(testDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keep a file in the directory, so that it isn't emptied
+	if err := testutil.SetupFiles(testDir, map[string]string{"keep": "keep"}); err != nil {
+		t.Fatal(err)
+	}
+	layer, err := snapshotter.TakeSnapshotOfFiles([]string{filepath.Join(testDir, "keep")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readLayer(t, layer)
+	// Delete a file and a directory, and add a file, as a traced RUN command might
+	if err := os.Remove(filepath.Join(testDir, "foo")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.RemoveAll(filepath.Join(testDir, "bar")); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.SetupFiles(testDir, map[string]string{"new": "new"}); err != nil {
+		t.Fatal(err)
+	}
+	// Files which didn't exist before the command ran don't need whiteouts
+	layer, err = snapshotter.TakeSnapshotOfFiles([]string{
+		filepath.Join(testDir, "foo"),
+		filepath.Join(testDir, "bar"),
+		filepath.Join(testDir, "new"),
+		filepath.Join(testDir, "temporary"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedEntries := []string{
+		filepath.Join(testDir, ".wh.bar"),
+		filepath.Join(testDir, ".wh.foo"),
+		filepath.Join(testDir, "new"),
+	}
+	actualEntries, err := tarEntries(readLayer(t, layer))
+	testutil.CheckErrorAndDeepEqual(t, false, err, expectedEntries, actualEntries)
+
+	// The files beneath the deleted directory should be gone from the layered map too
+	if _, ok := snapshotter.l.Get(filepath.Join(testDir, "bar/bat")); ok {
+		t.Error("Expected files beneath deleted directory to be whited out")
+	}
+}
+
+// readLayer returns the uncompressed contents of layer, and removes it
+func readLayer(t *testing.T, layer *util.Layer) []byte {
+	defer os.Remove(layer.Path)
+	r, err := layer.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	contents, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return contents
+}
+
+// tarEntries returns the sorted names of all entries in the tar
+func tarEntries(contents []byte) ([]string, error) {
+	var entries []string
+	tr := tar.NewReader(bytes.NewReader(contents))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, hdr.Name)
+	}
+	sort.Strings(entries)
+	return entries, nil
+}
+
 func setUpTestDir() (string, *Snapshotter, error) {
 	testDir, err := ioutil.TempDir("", "")
 	if err != nil {
@@ -208,7 +454,7 @@ func setUpTestDir() (string, *Snapshotter, error) {
 
 	// Take the initial snapshot
 	l := NewLayeredMap(util.Hasher())
-	snapshotter := NewSnapshotter(l, testDir)
+	snapshotter := NewSnapshotter(l, testDir, os.TempDir())
 	if err := snapshotter.Init(); err != nil {
 		return testDir, nil, errors.Wrap(err, "initializing snapshotter")
 	}
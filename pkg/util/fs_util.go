@@ -20,12 +20,14 @@ import (
 	"bufio"
 	pkgutil "github.com/GoogleCloudPlatform/container-diff/pkg/util"
 	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
-	"github.com/containers/image/docker"
+	"github.com/containers/image/types"
+	"github.com/docker/docker/pkg/fileutils"
 	"github.com/sirupsen/logrus"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -33,26 +35,64 @@ import (
 var whitelist = []string{"/kaniko"}
 var volumeWhitelist = []string{}
 
-// ExtractFileSystemFromImage pulls an image and unpacks it to a file system at root
-func ExtractFileSystemFromImage(img string) error {
+// dockerignore matches the files excluded from the build context at dockerignoreContext
+var dockerignore *fileutils.PatternMatcher
+var dockerignoreContext string
+
+// ExtractFileSystemFromImage unpacks the image ref refers to, as returned by PullImage, to a file system at root
+// If ref is nil, the stage has no base image, so there is nothing to unpack.
+func ExtractFileSystemFromImage(ref types.ImageReference) error {
 	whitelist, err := fileSystemWhitelist(constants.WhitelistPath)
 	if err != nil {
 		return err
 	}
 	logrus.Infof("Whitelisted directories are %s", whitelist)
-	if img == constants.NoBaseImage {
+	if ref == nil {
 		logrus.Info("No base image, nothing to extract")
 		return nil
 	}
-	ref, err := docker.ParseReference("//" + img)
-	if err != nil {
-		return err
-	}
-	imgSrc, err := ref.NewImageSource(nil)
+	return pkgutil.GetFileSystemFromReference(ref, nil, constants.RootDir, whitelist)
+}
+
+// ExtractFileSystemFromStage unpacks the saved image of a previous stage to its dependency directory,
+// so that its files can be copied into later stages with COPY --from
+func ExtractFileSystemFromStage(index int) error {
+	stage := strconv.Itoa(index)
+	ref, err := ImageReference(stage)
 	if err != nil {
 		return err
 	}
-	return pkgutil.GetFileSystemFromReference(ref, imgSrc, constants.RootDir, whitelist)
+	dir := StageDependencyDir(stage)
+	logrus.Infof("Unpacking filesystem of stage %d to %s", index, dir)
+	return pkgutil.GetFileSystemFromReference(ref, nil, dir, nil)
+}
+
+// DeleteFilesystem deletes the file system at root, leaving whitelisted directories alone.
+// Used between stages of a multi-stage build, so that the next stage starts from its own base image.
+func DeleteFilesystem() error {
+	logrus.Info("Deleting filesystem...")
+	return filepath.Walk(constants.RootDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if path == constants.RootDir || PathInWhitelist(path, constants.RootDir) {
+			return nil
+		}
+		// Directories containing whitelisted paths can't be removed entirely, so walk through them instead
+		if info.IsDir() && childInWhitelist(path, constants.RootDir) {
+			return nil
+		}
+		if err := os.RemoveAll(path); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
 }
 
 // PathInWhitelist returns true if the path is whitelisted
@@ -66,6 +106,17 @@ func PathInWhitelist(path, directory string) bool {
 	return false
 }
 
+// childInWhitelist returns true if any whitelisted path is within the directory path
+func childInWhitelist(path, directory string) bool {
+	for _, d := range whitelist {
+		dirPath := filepath.Join(directory, d)
+		if pkgutil.HasFilepathPrefix(dirPath, path) {
+			return true
+		}
+	}
+	return false
+}
+
 // Get whitelist from roots of mounted files
 // Each line of /proc/self/mountinfo is in the form:
 // 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
@@ -118,12 +169,84 @@ func RelativeFiles(fp string, root string) ([]string, error) {
 		if err != nil {
 			return err
 		}
+		if ExcludeFile(relPath, root) {
+			// Files within an excluded directory can only be included again by an exception
+			if info.IsDir() && !dockerignore.Exclusions() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
 		files = append(files, relPath)
 		return nil
 	})
 	return files, err
 }
 
+// LoadDockerignore reads the patterns in the .dockerignore file at the root of the build context, if there is one
+// Files in the build context which match the patterns are excluded whenever sources are resolved within it.
+func LoadDockerignore(buildcontext string) error {
+	dockerignore = nil
+	dockerignoreContext = filepath.Clean(buildcontext)
+	f, err := os.Open(filepath.Join(buildcontext, constants.Dockerignore))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	patterns, err := parseDockerignore(f)
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Excluding files matching %v in %s", patterns, constants.Dockerignore)
+	dockerignore, err = fileutils.NewPatternMatcher(patterns)
+	return err
+}
+
+// parseDockerignore reads the patterns in a .dockerignore file, the same way docker build does
+// Lines starting with # are comments, and patterns are cleaned and made relative to the build context;
+// a leading ! marks an exception.
+func parseDockerignore(r io.Reader) ([]string, error) {
+	var patterns []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		pattern := strings.TrimSpace(scanner.Text())
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+		exception := strings.HasPrefix(pattern, "!")
+		if exception {
+			pattern = strings.TrimSpace(pattern[1:])
+		}
+		if pattern != "" {
+			pattern = filepath.ToSlash(filepath.Clean(pattern))
+			if len(pattern) > 1 && pattern[0] == '/' {
+				pattern = pattern[1:]
+			}
+		}
+		if exception {
+			pattern = "!" + pattern
+		}
+		patterns = append(patterns, pattern)
+	}
+	return patterns, scanner.Err()
+}
+
+// ExcludeFile returns true if path, relative to root, is excluded by the .dockerignore file of the build context
+// Only files in the build context can be excluded, so this is always false if root isn't the build context.
+func ExcludeFile(path, root string) bool {
+	if dockerignore == nil || filepath.Clean(root) != dockerignoreContext {
+		return false
+	}
+	excluded, err := dockerignore.Matches(path)
+	if err != nil {
+		logrus.Debugf("Error matching %s against %s: %s", path, constants.Dockerignore, err)
+		return false
+	}
+	return excluded
+}
+
 // Files returns a list of all files rooted at root
 func Files(root string) ([]string, error) {
 	var files []string
@@ -203,3 +326,13 @@ func DownloadFileToDest(rawurl, dest string) error {
 	}
 	return os.Chtimes(dest, mTime, mTime)
 }
+
+// ExtractLayer unpacks a layer to root, skipping whitelisted directories
+func ExtractLayer(layer *Layer) error {
+	r, err := layer.Uncompressed()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return pkgutil.UnTar(r, constants.RootDir, whitelist)
+}
@@ -130,3 +130,106 @@ func Test_RelativeFiles(t *testing.T) {
 		testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedFiles, actualFiles)
 	}
 }
+
+var dockerignoreTests = []struct {
+	description   string
+	dockerignore  string
+	directory     string
+	expectedFiles []string
+}{
+	{
+		description:  "exclude files and directories",
+		dockerignore: "# comment\n.git\n*.md\n/secrets\n",
+		directory:    "",
+		expectedFiles: []string{
+			".",
+			".dockerignore",
+			"app",
+			"app/main.go",
+			"app/README.md",
+			"node_modules",
+			"node_modules/dep.js",
+		},
+	},
+	{
+		description:  "exceptions",
+		dockerignore: "*.md\n!README.md\nnode_modules\n!node_modules/dep.js\n",
+		directory:    "",
+		expectedFiles: []string{
+			".",
+			".dockerignore",
+			".git",
+			".git/HEAD",
+			"README.md",
+			"app",
+			"app/README.md",
+			"app/main.go",
+			"node_modules/dep.js",
+			"secrets",
+			"secrets/key",
+		},
+	},
+	{
+		description:   "excluded source directory",
+		dockerignore:  "secrets",
+		directory:     "secrets",
+		expectedFiles: nil,
+	},
+	{
+		description:  "patterns are relative to the build context",
+		dockerignore: "app/**/*.md\n",
+		directory:    "app",
+		expectedFiles: []string{
+			"app",
+			"app/main.go",
+		},
+	},
+}
+
+func Test_RelativeFilesWithDockerignore(t *testing.T) {
+	files := map[string]string{
+		".git/HEAD":           "ref: refs/heads/master",
+		"README.md":           "readme",
+		"CONTRIBUTING.md":     "contributing",
+		"app/main.go":         "package main",
+		"app/README.md":       "readme",
+		"node_modules/dep.js": "dep",
+		"secrets/key":         "secret",
+	}
+	for _, test := range dockerignoreTests {
+		t.Run(test.description, func(t *testing.T) {
+			testDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatalf("err setting up temp dir: %v", err)
+			}
+			defer os.RemoveAll(testDir)
+			if err := testutil.SetupFiles(testDir, files); err != nil {
+				t.Fatalf("err setting up files: %v", err)
+			}
+			if err := ioutil.WriteFile(filepath.Join(testDir, ".dockerignore"), []byte(test.dockerignore), 0644); err != nil {
+				t.Fatalf("err writing .dockerignore: %v", err)
+			}
+			if err := LoadDockerignore(testDir); err != nil {
+				t.Fatalf("err loading .dockerignore: %v", err)
+			}
+			defer LoadDockerignore("")
+
+			actualFiles, err := RelativeFiles(test.directory, testDir)
+			sort.Strings(actualFiles)
+			sort.Strings(test.expectedFiles)
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expectedFiles, actualFiles)
+
+			// Files outside the build context, such as those of previous stages, aren't excluded
+			otherDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatalf("err setting up temp dir: %v", err)
+			}
+			defer os.RemoveAll(otherDir)
+			if err := testutil.SetupFiles(otherDir, files); err != nil {
+				t.Fatalf("err setting up files: %v", err)
+			}
+			otherFiles, err := RelativeFiles("", otherDir)
+			testutil.CheckErrorAndDeepEqual(t, false, err, 12, len(otherFiles))
+		})
+	}
+}
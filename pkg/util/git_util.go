@@ -0,0 +1,275 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+	gossh "golang.org/x/crypto/ssh"
+	"gopkg.in/src-d/go-git.v4"
+	"gopkg.in/src-d/go-git.v4/config"
+	"gopkg.in/src-d/go-git.v4/plumbing"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+)
+
+// GitContextPrefix is the prefix of build contexts which are git repositories
+const GitContextPrefix = "git://"
+
+// scpURL matches git URLs in the scp-like syntax of SSH, such as git@github.com:org/repo.git
+var scpURL = regexp.MustCompile(`^[\w.-]+@[\w.-]+:`)
+
+// GitContext is a git repository to use as a build context
+type GitContext struct {
+	// URL is the URL to clone the repository from
+	URL string
+	// Ref is the branch, tag, commit or other ref to check out, or HEAD
+	Ref string
+	// Subdir is the directory within the repository to use as the build context, or "" for the whole repository
+	Subdir string
+}
+
+// GitOptions configures how git build contexts are cloned
+type GitOptions struct {
+	// SSHKey is the path of a private key to authenticate with over SSH.
+	// A known_hosts file next to it is used to verify hosts.
+	SSHKey string
+	// TokenFile is the path of a file containing a token to authenticate with over HTTPS, as Username
+	TokenFile string
+	Username  string
+	// RecurseSubmodules checks out the submodules of the repository too
+	RecurseSubmodules bool
+}
+
+// IsGitContext returns true if the build context context is a git repository
+func IsGitContext(context string) bool {
+	return strings.HasPrefix(context, GitContextPrefix)
+}
+
+// ParseGitContext parses a build context of the form git://<url>#<ref>:<subdir>, where the ref and subdirectory are optional.
+// URLs without a scheme are cloned over HTTPS, unless they are in the scp-like syntax of SSH;
+// other transports are given in full, as in git://ssh://git@github.com/org/repo.git.
+func ParseGitContext(context string) (GitContext, error) {
+	if !IsGitContext(context) {
+		return GitContext{}, errors.Errorf("%s is not a git build context", context)
+	}
+	url := strings.TrimPrefix(context, GitContextPrefix)
+	var fragment string
+	if i := strings.Index(url, "#"); i >= 0 {
+		url, fragment = url[:i], url[i+1:]
+	}
+	if url == "" {
+		return GitContext{}, errors.Errorf("no repository given in git build context %s", context)
+	}
+	if !strings.Contains(url, "://") && !scpURL.MatchString(url) {
+		url = "https://" + url
+	}
+	ref, subdir := fragment, ""
+	if i := strings.Index(fragment, ":"); i >= 0 {
+		ref, subdir = fragment[:i], fragment[i+1:]
+	}
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if subdir != "" {
+		subdir = filepath.Clean(subdir)
+		if filepath.IsAbs(subdir) || subdir == ".." || strings.HasPrefix(subdir, "../") {
+			return GitContext{}, errors.Errorf("subdirectory %s of git build context %s is outside of the repository", subdir, context)
+		}
+	}
+	return GitContext{URL: url, Ref: ref, Subdir: subdir}, nil
+}
+
+// CloneGitContext makes a shallow clone of the ref of the repository in dir, and returns the path of the build context within it
+// Repositories are cloned with a git client built into kaniko, so the git binary is only needed for file:// URLs.
+func CloneGitContext(context GitContext, dir string, opts GitOptions) (string, error) {
+	auth, err := gitAuth(context.URL, opts)
+	if err != nil {
+		return "", err
+	}
+	logrus.Infof("Cloning %s at %s", context.URL, context.Ref)
+	repo, err := git.PlainInit(dir, false)
+	if err != nil {
+		return "", err
+	}
+	remote, err := repo.CreateRemote(&config.RemoteConfig{Name: git.DefaultRemoteName, URLs: []string{context.URL}})
+	if err != nil {
+		return "", err
+	}
+	hash, err := fetchRef(repo, remote, context.Ref, auth)
+	if err != nil {
+		return "", errors.Wrapf(err, "fetching %s from %s", context.Ref, context.URL)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return "", err
+	}
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: hash, Force: true}); err != nil {
+		return "", errors.Wrapf(err, "checking out %s", hash)
+	}
+	if opts.RecurseSubmodules {
+		submodules, err := worktree.Submodules()
+		if err != nil {
+			return "", err
+		}
+		if err := submodules.Update(&git.SubmoduleUpdateOptions{
+			Init:              true,
+			RecurseSubmodules: git.DefaultSubmoduleRecursionDepth,
+			Auth:              auth,
+		}); err != nil {
+			return "", errors.Wrap(err, "updating submodules")
+		}
+	}
+	logrus.Infof("Checked out %s at commit %s", context.URL, hash)
+
+	contextDir := filepath.Join(dir, context.Subdir)
+	if fi, err := os.Stat(contextDir); err != nil || !fi.IsDir() {
+		return "", errors.Errorf("%s is not a directory in %s", context.Subdir, context.URL)
+	}
+	return contextDir, nil
+}
+
+// fetchRef fetches ref from remote into repo, and returns the commit it refers to
+// Refs the remote advertises are fetched by themselves with a depth of one. Only commits which refs point to
+// can be fetched from some servers, so any other ref, such as a commit, is resolved after fetching every branch and tag.
+func fetchRef(repo *git.Repository, remote *git.Remote, ref string, auth transport.AuthMethod) (plumbing.Hash, error) {
+	refs, err := remote.List(&git.ListOptions{Auth: auth})
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	opts := &git.FetchOptions{
+		Auth:     auth,
+		RefSpecs: []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+		Tags:     git.AllTags,
+	}
+	name, advertised := advertisedRef(refs, ref)
+	if advertised {
+		opts.RefSpecs = []config.RefSpec{config.RefSpec(fmt.Sprintf("+%s:%s", name, name))}
+		opts.Depth = 1
+		opts.Tags = git.NoTags
+	} else {
+		logrus.Debugf("%s isn't a ref of the repository, fetching the whole repository", ref)
+	}
+	if err := remote.Fetch(opts); err != nil && err != git.NoErrAlreadyUpToDate {
+		return plumbing.ZeroHash, err
+	}
+	var hash plumbing.Hash
+	if advertised {
+		r, err := repo.Reference(name, true)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		hash = r.Hash()
+	} else {
+		h, err := repo.ResolveRevision(plumbing.Revision(ref))
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		hash = *h
+	}
+	// Annotated tags refer to the tag object rather than the commit
+	if tag, err := repo.TagObject(hash); err == nil {
+		commit, err := tag.Commit()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		return commit.Hash, nil
+	}
+	return hash, nil
+}
+
+// advertisedRef returns the full name of the ref among refs that ref refers to, in the order git resolves refs in
+// HEAD is resolved to the branch it points to.
+func advertisedRef(refs []*plumbing.Reference, ref string) (plumbing.ReferenceName, bool) {
+	byName := map[plumbing.ReferenceName]*plumbing.Reference{}
+	for _, r := range refs {
+		byName[r.Name()] = r
+	}
+	for _, rule := range append([]string{"%s"}, plumbing.RefRevParseRules...) {
+		r, ok := byName[plumbing.ReferenceName(fmt.Sprintf(rule, ref))]
+		if !ok {
+			continue
+		}
+		if r.Type() == plumbing.SymbolicReference {
+			r, ok = byName[r.Target()]
+			if !ok {
+				return "", false
+			}
+		}
+		if r.Name() != plumbing.HEAD {
+			return r.Name(), true
+		}
+		// The remote didn't say which branch HEAD points to, so use a branch at the same commit
+		for _, branch := range refs {
+			if branch.Name().IsBranch() && branch.Hash() == r.Hash() {
+				return branch.Name(), true
+			}
+		}
+		return "", false
+	}
+	return "", false
+}
+
+// gitAuth returns the credentials in opts for the transport of url, or nil if there are none
+func gitAuth(url string, opts GitOptions) (transport.AuthMethod, error) {
+	endpoint, err := transport.NewEndpoint(url)
+	if err != nil {
+		return nil, err
+	}
+	switch endpoint.Protocol {
+	case "ssh":
+		if opts.SSHKey == "" {
+			return nil, nil
+		}
+		user := endpoint.User
+		if user == "" {
+			user = "git"
+		}
+		auth, err := ssh.NewPublicKeysFromFile(user, opts.SSHKey, "")
+		if err != nil {
+			return nil, errors.Wrap(err, "reading SSH key for git")
+		}
+		knownHosts := filepath.Join(filepath.Dir(opts.SSHKey), "known_hosts")
+		if _, err := os.Stat(knownHosts); err == nil {
+			if auth.HostKeyCallback, err = ssh.NewKnownHostsCallback(knownHosts); err != nil {
+				return nil, errors.Wrap(err, "reading known_hosts for git")
+			}
+		} else {
+			logrus.Warnf("No known_hosts file found next to %s, so the host keys of git servers will not be verified", opts.SSHKey)
+			auth.HostKeyCallback = gossh.InsecureIgnoreHostKey()
+		}
+		return auth, nil
+	case "http", "https":
+		if opts.TokenFile == "" {
+			return nil, nil
+		}
+		token, err := ioutil.ReadFile(opts.TokenFile)
+		if err != nil {
+			return nil, errors.Wrap(err, "reading token for git")
+		}
+		return &http.BasicAuth{Username: opts.Username, Password: strings.TrimSpace(string(token))}, nil
+	}
+	return nil, nil
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/http"
+	"gopkg.in/src-d/go-git.v4/plumbing/transport/ssh"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var parseGitContextTests = []struct {
+	context   string
+	expected  GitContext
+	shouldErr bool
+}{
+	{
+		context:  "git://github.com/org/repo.git",
+		expected: GitContext{URL: "https://github.com/org/repo.git", Ref: "HEAD"},
+	},
+	{
+		context:  "git://github.com/org/repo.git#refs/heads/release:app/web",
+		expected: GitContext{URL: "https://github.com/org/repo.git", Ref: "refs/heads/release", Subdir: "app/web"},
+	},
+	{
+		context:  "git://github.com/org/repo.git#:app",
+		expected: GitContext{URL: "https://github.com/org/repo.git", Ref: "HEAD", Subdir: "app"},
+	},
+	{
+		context:  "git://git@github.com:org/repo.git#v1.0",
+		expected: GitContext{URL: "git@github.com:org/repo.git", Ref: "v1.0"},
+	},
+	{
+		context:  "git://ssh://git@github.com/org/repo.git",
+		expected: GitContext{URL: "ssh://git@github.com/org/repo.git", Ref: "HEAD"},
+	},
+	{
+		context:  "git://file:///srv/repo.git#master",
+		expected: GitContext{URL: "file:///srv/repo.git", Ref: "master"},
+	},
+	{
+		context:   "git://github.com/org/repo.git#master:../other",
+		shouldErr: true,
+	},
+	{
+		context:   "git://#master",
+		shouldErr: true,
+	},
+}
+
+func Test_ParseGitContext(t *testing.T) {
+	for _, test := range parseGitContextTests {
+		t.Run(test.context, func(t *testing.T) {
+			actual, err := ParseGitContext(test.context)
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, actual)
+		})
+	}
+}
+
+// runGit runs git in dir, failing the test if it fails, and returns its output
+func runGit(t *testing.T, dir string, args ...string) string {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = append(os.Environ(), "GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %v failed: %s: %s", args, err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// newTestRepo creates a bare repository in dir/name.git from the files, committed to master, and returns its URL
+func newTestRepo(t *testing.T, dir, name string, files map[string]string) string {
+	work := filepath.Join(dir, name)
+	if err := testutil.SetupFiles(work, files); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "init", "-q")
+	runGit(t, work, "checkout", "-q", "-b", "master")
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-q", "-m", "initial")
+	bare := filepath.Join(dir, name+".git")
+	runGit(t, dir, "clone", "-q", "--bare", work, bare)
+	return "file://" + bare
+}
+
+func Test_CloneGitContext(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	url := newTestRepo(t, dir, "repo", map[string]string{
+		"Dockerfile":     "FROM scratch",
+		"app/Dockerfile": "FROM scratch\nCOPY . /app",
+	})
+	work := filepath.Join(dir, "repo")
+	first := runGit(t, work, "rev-parse", "HEAD")
+	runGit(t, work, "tag", "v1")
+	if err := ioutil.WriteFile(filepath.Join(work, "app", "main.go"), []byte("package main"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runGit(t, work, "add", "-A")
+	runGit(t, work, "commit", "-q", "-m", "second")
+	runGit(t, work, "push", "-q", "--tags", url, "master")
+
+	var tests = []struct {
+		name      string
+		context   string
+		subdir    string
+		expected  []string
+		shouldErr bool
+	}{
+		{
+			name:     "default branch",
+			context:  "git://" + url,
+			subdir:   "app",
+			expected: []string{"Dockerfile", "main.go"},
+		},
+		{
+			name:     "subdirectory of branch",
+			context:  "git://" + url + "#master:app",
+			expected: []string{"Dockerfile", "main.go"},
+		},
+		{
+			name:     "tag",
+			context:  "git://" + url + "#v1:app",
+			expected: []string{"Dockerfile"},
+		},
+		{
+			name:     "commit",
+			context:  "git://" + url + "#" + first + ":app",
+			expected: []string{"Dockerfile"},
+		},
+		{
+			name:      "missing subdirectory",
+			context:   "git://" + url + "#master:missing",
+			shouldErr: true,
+		},
+		{
+			name:      "missing ref",
+			context:   "git://" + url + "#missing",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cloneDir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(cloneDir)
+			gitContext, err := ParseGitContext(test.context)
+			if err != nil {
+				t.Fatal(err)
+			}
+			contextDir, err := CloneGitContext(gitContext, filepath.Join(cloneDir, "buildcontext"), GitOptions{})
+			var files []string
+			if err == nil {
+				infos, err := ioutil.ReadDir(filepath.Join(contextDir, test.subdir))
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, info := range infos {
+					files = append(files, info.Name())
+				}
+			}
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, files)
+		})
+	}
+}
+
+func Test_CloneGitContextSubmodules(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// git only adds submodules over the file transport if it is allowed
+	for key, value := range map[string]string{"GIT_CONFIG_COUNT": "1", "GIT_CONFIG_KEY_0": "protocol.file.allow", "GIT_CONFIG_VALUE_0": "always"} {
+		os.Setenv(key, value)
+		defer os.Unsetenv(key)
+	}
+	libURL := newTestRepo(t, dir, "lib", map[string]string{"lib.go": "package lib"})
+	url := newTestRepo(t, dir, "repo", map[string]string{"Dockerfile": "FROM scratch"})
+	work := filepath.Join(dir, "repo")
+	runGit(t, work, "submodule", "-q", "add", libURL, "lib")
+	runGit(t, work, "commit", "-q", "-m", "add lib")
+	runGit(t, work, "push", "-q", url, "master")
+
+	for _, recurse := range []bool{false, true} {
+		cloneDir, err := ioutil.TempDir("", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(cloneDir)
+		gitContext, err := ParseGitContext("git://" + url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contextDir, err := CloneGitContext(gitContext, cloneDir, GitOptions{RecurseSubmodules: recurse})
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.CheckErrorAndDeepEqual(t, false, nil, recurse, FilepathExists(filepath.Join(contextDir, "lib", "lib.go")))
+	}
+}
+
+func Test_gitAuth(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshKey := filepath.Join(dir, "id_rsa")
+	if err := ioutil.WriteFile(sshKey, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts := GitOptions{SSHKey: sshKey, TokenFile: tokenFile, Username: "oauth2"}
+
+	auth, err := gitAuth("https://github.com/org/repo.git", opts)
+	testutil.CheckErrorAndDeepEqual(t, false, err, &http.BasicAuth{Username: "oauth2", Password: "secret"}, auth)
+
+	for url, user := range map[string]string{"git@github.com:org/repo.git": "git", "ssh://deploy@github.com/org/repo.git": "deploy"} {
+		auth, err := gitAuth(url, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		publicKeys, ok := auth.(*ssh.PublicKeys)
+		if !ok {
+			t.Fatalf("expected SSH public keys for %s, got %T", url, auth)
+		}
+		testutil.CheckErrorAndDeepEqual(t, false, nil, user, publicKeys.User)
+	}
+
+	auth, err = gitAuth("https://github.com/org/repo.git", GitOptions{})
+	testutil.CheckErrorAndDeepEqual(t, false, err, nil, auth)
+	auth, err = gitAuth("file:///srv/repo.git", opts)
+	testutil.CheckErrorAndDeepEqual(t, false, err, nil, auth)
+	_, err = gitAuth("https://github.com/org/repo.git", GitOptions{TokenFile: filepath.Join(dir, "missing")})
+	testutil.CheckError(t, true, err)
+}
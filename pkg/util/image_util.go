@@ -0,0 +1,150 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/containers/image/docker"
+	"github.com/containers/image/docker/archive"
+	"github.com/containers/image/types"
+	"github.com/docker/distribution/reference"
+	"github.com/sirupsen/logrus"
+)
+
+// ImageReference returns a reference to the image img
+// If img is the index of a previous stage, the reference points to the tarball that stage was saved to.
+// Otherwise it points to the image in the first mirror of its registry which has it, or in its registry,
+// which is accessed with the credentials found for it.
+func ImageReference(img string) (types.ImageReference, error) {
+	if index, err := strconv.Atoi(img); err == nil {
+		return archive.ParseReference(StageTarballPath(index))
+	}
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	r, err := currentRegistries()
+	if err != nil {
+		return nil, err
+	}
+	if ref, ok := r.pulls[img]; ok {
+		return ref, nil
+	}
+	ref, err := docker.ParseReference("//" + img)
+	if err != nil {
+		return nil, err
+	}
+	pullRef, err := r.pullReference(ref)
+	if err != nil {
+		return nil, err
+	}
+	r.pulls[img] = pullRef
+	return pullRef, nil
+}
+
+// pullReference returns the reference to pull the image ref refers to from
+// Mirrors which are unavailable, or don't have the image, are skipped.
+func (r *registryConfig) pullReference(ref types.ImageReference) (types.ImageReference, error) {
+	named := ref.DockerReference()
+	for _, mirror := range r.mirrors[reference.Domain(named)] {
+		mirrorRef, err := r.mirrorReference(named, mirror)
+		if err == nil {
+			err = checkManifest(mirrorRef)
+		}
+		if err != nil {
+			logrus.Warnf("Unable to pull %s from mirror %s, trying the next source: %s", named, mirror, err)
+			continue
+		}
+		logrus.Infof("Pulling %s from mirror %s", named, mirrorRef.DockerReference())
+		return mirrorRef, nil
+	}
+	return r.withSystemContext(ref)
+}
+
+// mirrorReference returns a reference to the image named in mirror, which has the same path and tag or digest
+func (r *registryConfig) mirrorReference(named reference.Named, mirror string) (types.ImageReference, error) {
+	// Mirrors are often given as URLs, as in the docker daemon config
+	mirror = strings.TrimPrefix(strings.TrimPrefix(mirror, "https://"), "http://")
+	img := strings.TrimSuffix(mirror, "/") + "/" + reference.Path(named)
+	if tagged, ok := named.(reference.Tagged); ok {
+		img += ":" + tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		img += "@" + digested.Digest().String()
+	}
+	ref, err := docker.ParseReference("//" + img)
+	if err != nil {
+		return nil, err
+	}
+	return r.withSystemContext(ref)
+}
+
+// withSystemContext returns ref, accessed with the context for its registry
+func (r *registryConfig) withSystemContext(ref types.ImageReference) (types.ImageReference, error) {
+	sys, err := r.systemContext(reference.Domain(ref.DockerReference()))
+	if err != nil {
+		return nil, err
+	}
+	return registryReference{ref, sys}, nil
+}
+
+// checkManifest makes sure that the image ref refers to exists, by getting its manifest
+func checkManifest(ref types.ImageReference) error {
+	src, err := ref.NewImageSource(nil)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	_, _, err = src.GetManifest(nil)
+	return err
+}
+
+// registryReference is a reference to an image in a registry which is always accessed with sys,
+// since the libraries which pull images access them without a SystemContext
+type registryReference struct {
+	types.ImageReference
+	sys *types.SystemContext
+}
+
+func (r registryReference) NewImage(*types.SystemContext) (types.ImageCloser, error) {
+	return r.ImageReference.NewImage(r.sys)
+}
+
+func (r registryReference) NewImageSource(*types.SystemContext) (types.ImageSource, error) {
+	return r.ImageReference.NewImageSource(r.sys)
+}
+
+func (r registryReference) NewImageDestination(*types.SystemContext) (types.ImageDestination, error) {
+	return r.ImageReference.NewImageDestination(r.sys)
+}
+
+func (r registryReference) DeleteImage(*types.SystemContext) error {
+	return r.ImageReference.DeleteImage(r.sys)
+}
+
+// StageTarballPath returns the path the image built by the stage at index is saved to
+func StageTarballPath(index int) string {
+	return filepath.Join(constants.KanikoIntermediateStagesDir, strconv.Itoa(index))
+}
+
+// StageDependencyDir returns the directory the file system of a stage is unpacked to,
+// which later stages can copy files from with COPY --from=<stage>
+func StageDependencyDir(stage string) string {
+	return filepath.Join(constants.KanikoDir, stage)
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+
+	digest "github.com/opencontainers/go-digest"
+	"github.com/pkg/errors"
+)
+
+const (
+	// layerCompressionLevel is the gzip level layers are compressed with
+	layerCompressionLevel = 6
+	// gzipUnknownOS is the operating system recorded in the gzip header of layers, so that it doesn't depend on the build
+	gzipUnknownOS = 255
+)
+
+// Layer is a gzip compressed layer tarball stored in a file, so that it doesn't need to be held in memory
+type Layer struct {
+	Path string
+	// Digest and Size are the digest and size of the compressed tarball
+	Digest digest.Digest
+	Size   int64
+	// DiffID is the digest of the uncompressed tarball
+	DiffID digest.Digest
+}
+
+// Open returns a reader for the compressed tarball
+func (l *Layer) Open() (io.ReadCloser, error) {
+	return os.Open(l.Path)
+}
+
+// Uncompressed returns a reader for the uncompressed tarball
+func (l *Layer) Uncompressed() (io.ReadCloser, error) {
+	f, err := os.Open(l.Path)
+	if err != nil {
+		return nil, err
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return readCloser{gz, f}, nil
+}
+
+// LayerWriter compresses an uncompressed layer tarball to a file in a directory as it is written,
+// computing the digests of the uncompressed and compressed tarball on the fly
+type LayerWriter struct {
+	file   *os.File
+	gz     *gzip.Writer
+	w      io.Writer
+	diffID digest.Digester
+	digest digest.Digester
+}
+
+// NewLayerWriter creates a new layer file in dir
+func NewLayerWriter(dir string) (*LayerWriter, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	f, err := ioutil.TempFile(dir, "layer")
+	if err != nil {
+		return nil, err
+	}
+	l := &LayerWriter{
+		file:   f,
+		diffID: digest.Canonical.Digester(),
+		digest: digest.Canonical.Digester(),
+	}
+	// The level and header are set explicitly, since they determine the digest of a layer built reproducibly
+	l.gz, err = gzip.NewWriterLevel(io.MultiWriter(f, l.digest.Hash()), layerCompressionLevel)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	l.gz.Header = gzip.Header{OS: gzipUnknownOS}
+	l.w = io.MultiWriter(l.diffID.Hash(), l.gz)
+	return l, nil
+}
+
+// Write writes uncompressed tarball contents to the layer
+func (l *LayerWriter) Write(p []byte) (int, error) {
+	return l.w.Write(p)
+}
+
+// Layer finishes writing the layer, and returns it
+func (l *LayerWriter) Layer() (*Layer, error) {
+	if err := l.gz.Close(); err != nil {
+		l.file.Close()
+		return nil, err
+	}
+	if err := l.file.Close(); err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(l.file.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Layer{
+		Path:   l.file.Name(),
+		Digest: l.digest.Digest(),
+		Size:   fi.Size(),
+		DiffID: l.diffID.Digest(),
+	}, nil
+}
+
+// Discard removes the layer, for when it turns out to be empty
+func (l *LayerWriter) Discard() error {
+	l.gz.Close()
+	l.file.Close()
+	return os.Remove(l.file.Name())
+}
+
+// WriteCompressedLayer writes the compressed layer tarball read from r to a file in dir, checking that it has digest dgst
+func WriteCompressedLayer(dir string, r io.Reader, dgst, diffID digest.Digest) (*Layer, error) {
+	path, size, err := writeBlob(dir, r, dgst)
+	if err != nil {
+		return nil, err
+	}
+	return &Layer{
+		Path:   path,
+		Digest: dgst,
+		Size:   size,
+		DiffID: diffID,
+	}, nil
+}
+
+// writeBlob writes the blob read from r to a new file in dir, checking that it has digest dgst,
+// and returns the path and size of the file
+func writeBlob(dir string, r io.Reader, dgst digest.Digest) (string, int64, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", 0, err
+	}
+	f, err := ioutil.TempFile(dir, "layer")
+	if err != nil {
+		return "", 0, err
+	}
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(io.MultiWriter(f, digester.Hash()), r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil && digester.Digest() != dgst {
+		err = errors.Errorf("blob has digest %s, expected %s", digester.Digest(), dgst)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", 0, err
+	}
+	return f.Name(), size, nil
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package util
+
+import (
+	"bytes"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_LayerWriter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	layersDir := filepath.Join(dir, "layers")
+
+	contents := bytes.Repeat([]byte("kaniko"), 100000)
+	lw, err := NewLayerWriter(layersDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Write in pieces, as a tar writer would
+	for i := 0; i < len(contents); i += 512 {
+		end := i + 512
+		if end > len(contents) {
+			end = len(contents)
+		}
+		if _, err := lw.Write(contents[i:end]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	layer, err := lw.Layer()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	compressed, err := ioutil.ReadFile(layer.Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, digest.FromBytes(compressed), layer.Digest)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, int64(len(compressed)), layer.Size)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, digest.FromBytes(contents), layer.DiffID)
+
+	r, err := layer.Uncompressed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	uncompressed, err := ioutil.ReadAll(r)
+	testutil.CheckErrorAndDeepEqual(t, false, err, contents, uncompressed)
+}
+
+func Test_LayerWriterDiscard(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	lw, err := NewLayerWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lw.Discard(); err != nil {
+		t.Fatal(err)
+	}
+	files, err := ioutil.ReadDir(dir)
+	testutil.CheckErrorAndDeepEqual(t, false, err, 0, len(files))
+}
+
+func Test_WriteCompressedLayer(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	compressed := []byte("compressed layer")
+	diffID := digest.FromString("uncompressed layer")
+	layer, err := WriteCompressedLayer(dir, bytes.NewReader(compressed), digest.FromBytes(compressed), diffID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := &Layer{
+		Path:   layer.Path,
+		Digest: digest.FromBytes(compressed),
+		Size:   int64(len(compressed)),
+		DiffID: diffID,
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, layer)
+
+	// A layer which doesn't match its digest shouldn't be kept
+	_, err = WriteCompressedLayer(dir, bytes.NewReader([]byte("corrupted layer")), digest.FromBytes(compressed), diffID)
+	testutil.CheckError(t, true, err)
+	files, err := ioutil.ReadDir(dir)
+	testutil.CheckErrorAndDeepEqual(t, false, err, 1, len(files))
+}
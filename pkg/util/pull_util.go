@@ -0,0 +1,229 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/containers/image/directory"
+	"github.com/containers/image/image"
+	"github.com/containers/image/manifest"
+	"github.com/containers/image/types"
+	"github.com/docker/distribution/reference"
+	digest "github.com/opencontainers/go-digest"
+	"github.com/sirupsen/logrus"
+)
+
+// blobsDir and imagesDir are where the blobs and manifests of pulled images are stored
+var (
+	blobsDir  = constants.KanikoBlobsDir
+	imagesDir = constants.KanikoImagesDir
+)
+
+// SetCacheDir stores the blobs and manifests of pulled images beneath dir, so that they can be kept between builds
+// on a persistent volume. The default directories are used if dir is empty.
+func SetCacheDir(dir string) {
+	if dir == "" {
+		blobsDir, imagesDir = constants.KanikoBlobsDir, constants.KanikoImagesDir
+		return
+	}
+	blobsDir, imagesDir = filepath.Join(dir, "blobs"), filepath.Join(dir, "images")
+}
+
+// platform is the platform chosen from manifest lists
+var platform = &types.SystemContext{
+	OSChoice:           "linux",
+	ArchitectureChoice: "amd64",
+}
+
+// PullImage resolves img to the digest of its manifest, and downloads its config and layers to the blob cache
+// unless they are there already. It returns a reference to the local copy of the image, which is read without
+// accessing its registry again, and so doesn't change if the tag of the image is moved during the build.
+// Images referred to by a digest which has been pulled before are used without accessing their registry at all.
+func PullImage(img string) (types.ImageReference, error) {
+	pinned := pinnedDigest(img)
+	if pinned != "" {
+		dir := filepath.Join(imagesDir, pinned.Hex())
+		if _, err := os.Stat(filepath.Join(dir, "manifest.json")); err == nil {
+			logrus.Infof("Using cached copy of %s", img)
+			return directory.NewReference(dir)
+		}
+	}
+	ref, err := ImageReference(img)
+	if err != nil {
+		return nil, err
+	}
+	src, err := ref.NewImageSource(nil)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	m, mediaType, err := resolveManifest(src)
+	if err != nil {
+		return nil, err
+	}
+	dgst := digest.FromBytes(m)
+	if named := ref.DockerReference(); named != nil {
+		logrus.Infof("Resolved %s to %s@%s", img, named.Name(), dgst)
+	}
+	dir := filepath.Join(imagesDir, dgst.Hex())
+	if _, err := os.Stat(filepath.Join(dir, "manifest.json")); err == nil {
+		logrus.Infof("Using cached copy of %s", img)
+	} else if err := storeImage(src, m, mediaType, dir); err != nil {
+		return nil, err
+	}
+	// A digest of a manifest list resolves to the image for this platform, which is linked to so that
+	// it is found by the digest it was pulled with too
+	if pinned != "" && pinned != dgst {
+		if err := os.Symlink(dgst.Hex(), filepath.Join(imagesDir, pinned.Hex())); err != nil && !os.IsExist(err) {
+			return nil, err
+		}
+	}
+	return directory.NewReference(dir)
+}
+
+// pinnedDigest returns the digest img refers to, or an empty digest if it refers to a tag
+func pinnedDigest(img string) digest.Digest {
+	ref, err := reference.ParseNormalizedNamed(img)
+	if err != nil {
+		return ""
+	}
+	if canonical, ok := ref.(reference.Canonical); ok {
+		return canonical.Digest()
+	}
+	return ""
+}
+
+// InspectImage resolves img to the digest of its manifest, and returns the digest and the config of the image
+// without downloading its layers
+func InspectImage(img string) (digest.Digest, *manifest.Schema2Config, error) {
+	ref, err := ImageReference(img)
+	if err != nil {
+		return "", nil, err
+	}
+	src, err := ref.NewImageSource(nil)
+	if err != nil {
+		return "", nil, err
+	}
+	defer src.Close()
+	m, mediaType, err := resolveManifest(src)
+	if err != nil {
+		return "", nil, err
+	}
+	mfst, err := manifest.FromBlob(m, mediaType)
+	if err != nil {
+		return "", nil, err
+	}
+	config := &manifest.Schema2Config{}
+	// Schema 1 manifests have no config
+	if info := mfst.ConfigInfo(); info.Digest != "" {
+		r, _, err := src.GetBlob(info)
+		if err != nil {
+			return "", nil, err
+		}
+		defer r.Close()
+		var image manifest.Schema2Image
+		if err := json.NewDecoder(r).Decode(&image); err != nil {
+			return "", nil, err
+		}
+		if image.Config != nil {
+			config = image.Config
+		}
+	}
+	return digest.FromBytes(m), config, nil
+}
+
+// resolveManifest returns the manifest of the image src provides, choosing the one for this platform from manifest lists
+func resolveManifest(src types.ImageSource) ([]byte, string, error) {
+	m, mediaType, err := src.GetManifest(nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if mediaType != manifest.DockerV2ListMediaType {
+		return m, mediaType, nil
+	}
+	instance, err := image.ChooseManifestInstanceFromManifestList(platform, image.UnparsedInstance(src, nil))
+	if err != nil {
+		return nil, "", err
+	}
+	return src.GetManifest(&instance)
+}
+
+// storeImage stores the image with manifest m in dir, in the layout of the dir transport, with its blobs linked
+// from the blob cache. The directory is only created once the image is complete, so a partial pull is never used.
+func storeImage(src types.ImageSource, m []byte, mediaType, dir string) error {
+	mfst, err := manifest.FromBlob(m, mediaType)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(imagesDir, 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempDir(imagesDir, "pull")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	for _, info := range append([]types.BlobInfo{mfst.ConfigInfo()}, mfst.LayerInfos()...) {
+		// Schema 1 manifests have no config
+		if info.Digest == "" {
+			continue
+		}
+		path, err := cacheBlob(src, info)
+		if err != nil {
+			return err
+		}
+		if err := os.Symlink(path, filepath.Join(tmp, info.Digest.Hex()+".tar")); err != nil {
+			return err
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "manifest.json"), m, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		// Another build sharing the cache directory may have stored the image first
+		if _, statErr := os.Stat(filepath.Join(dir, "manifest.json")); statErr == nil {
+			return nil
+		}
+		return err
+	}
+	return nil
+}
+
+// cacheBlob returns the path of the blob in the blob cache, downloading it from src if it isn't cached yet
+func cacheBlob(src types.ImageSource, info types.BlobInfo) (string, error) {
+	path := filepath.Join(blobsDir, info.Digest.Hex())
+	if _, err := os.Stat(path); err == nil {
+		logrus.Debugf("Blob %s is cached", info.Digest)
+		return path, nil
+	}
+	logrus.Debugf("Downloading blob %s", info.Digest)
+	r, _, err := src.GetBlob(info)
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+	tmp, _, err := writeBlob(blobsDir, r, info.Digest)
+	if err != nil {
+		return "", err
+	}
+	return path, os.Rename(tmp, path)
+}
@@ -0,0 +1,186 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	img "github.com/GoogleCloudPlatform/container-diff/pkg/image"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/manifest"
+	"github.com/opencontainers/go-digest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// addTestImage adds an image with a single layer to the repository test/image in registry, tagged with tag,
+// and returns its manifest
+func addTestImage(t *testing.T, registry *testutil.Registry, tag string, env string) []byte {
+	buf := bytes.NewBuffer([]byte{})
+	gz := gzip.NewWriter(buf)
+	w := tar.NewWriter(gz)
+	content := []byte("hello")
+	if err := w.WriteHeader(&tar.Header{Name: "foo", Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	config, err := json.Marshal(manifest.Schema2Image{
+		Schema2V1Image: manifest.Schema2V1Image{
+			Config: &manifest.Schema2Config{Env: []string{env}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := manifest.Schema2FromComponents(
+		manifest.Schema2Descriptor{
+			MediaType: manifest.DockerV2Schema2ConfigMediaType,
+			Size:      int64(len(config)),
+			Digest:    registry.AddBlob(config),
+		},
+		[]manifest.Schema2Descriptor{{
+			MediaType: manifest.DockerV2Schema2LayerMediaType,
+			Size:      int64(buf.Len()),
+			Digest:    registry.AddBlob(buf.Bytes()),
+		}},
+	).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	registry.AddManifest("test/image", tag, m, manifest.DockerV2Schema2MediaType)
+	return m
+}
+
+func Test_PullImage(t *testing.T) {
+	registry := testutil.NewRegistry()
+	defer registry.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	originalBlobsDir, originalImagesDir := blobsDir, imagesDir
+	blobsDir, imagesDir = filepath.Join(dir, "blobs"), filepath.Join(dir, "images")
+	defer func() { blobsDir, imagesDir = originalBlobsDir, originalImagesDir }()
+	defer SetRegistryOptions(RegistryOptions{})
+	if err := SetRegistryOptions(RegistryOptions{InsecureRegistries: []string{registry.Host()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := addTestImage(t, registry, "v1", "VERSION=1")
+	ref, err := PullImage(registry.Host() + "/test/image:v1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The config and the layer are downloaded
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 2, registry.BlobDownloads())
+
+	// Moving the tag doesn't change the pulled image
+	addTestImage(t, registry, "v1", "VERSION=2")
+	ms, err := img.NewMutableSource(ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"VERSION=1"}, ms.Config().Env)
+	// The layers of the source image are read from the blob cache
+	pulled, err := manifest.Schema2FromManifest(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, _, err := ms.GetBlob(pulled.LayerInfos()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 2, registry.BlobDownloads())
+
+	// Only the new config is downloaded, since the layer is cached
+	if _, err := PullImage(registry.Host() + "/test/image:v1"); err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 3, registry.BlobDownloads())
+
+	// Pulling an image which is cached downloads nothing
+	digestRef := registry.Host() + "/test/image@" + digest.FromBytes(m).String()
+	cached, err := PullImage(digestRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, 3, registry.BlobDownloads())
+	testutil.CheckErrorAndDeepEqual(t, false, nil, ref.StringWithinTransport(), cached.StringWithinTransport())
+}
+
+func Test_PullImageCacheDir(t *testing.T) {
+	registry := testutil.NewRegistry()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	SetCacheDir(dir)
+	defer SetCacheDir("")
+	defer SetRegistryOptions(RegistryOptions{})
+	if err := SetRegistryOptions(RegistryOptions{InsecureRegistries: []string{registry.Host()}}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := addTestImage(t, registry, "v1", "VERSION=1")
+	list := []byte(`{"schemaVersion": 2, "mediaType": "` + manifest.DockerV2ListMediaType + `", "manifests": [{"mediaType": "` +
+		manifest.DockerV2Schema2MediaType + `", "size": ` + strconv.Itoa(len(m)) + `, "digest": "` + digest.FromBytes(m).String() +
+		`", "platform": {"architecture": "amd64", "os": "linux"}}]}`)
+	registry.AddManifest("test/image", "list", list, manifest.DockerV2ListMediaType)
+	imageRef := registry.Host() + "/test/image@" + digest.FromBytes(m).String()
+	listRef := registry.Host() + "/test/image@" + digest.FromBytes(list).String()
+	for _, pulled := range []string{imageRef, listRef} {
+		if _, err := PullImage(pulled); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The config and the layer are stored in the cache directory
+	blobs, err := ioutil.ReadDir(filepath.Join(dir, "blobs"))
+	testutil.CheckErrorAndDeepEqual(t, false, err, 2, len(blobs))
+
+	// Images pulled by digest are used without accessing the registry, including those in manifest lists
+	registry.Close()
+	for _, pulled := range []string{imageRef, listRef} {
+		ref, err := PullImage(pulled)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ms, err := img.NewMutableSource(ref)
+		if err != nil {
+			t.Fatal(err)
+		}
+		testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"VERSION=1"}, ms.Config().Env)
+	}
+	// Tags still need to be resolved by the registry
+	_, err = PullImage(registry.Host() + "/test/image:v1")
+	testutil.CheckError(t, true, err)
+}
@@ -0,0 +1,428 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/version"
+	"github.com/containers/image/types"
+	"github.com/docker/distribution/reference"
+	"github.com/docker/docker-credential-helpers/client"
+	"github.com/docker/docker-credential-helpers/credentials"
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// RegistryOptions configure how registries are accessed
+type RegistryOptions struct {
+	// DockerConfig is a docker config file, or a directory containing one, to read credentials from.
+	// If it isn't set, config.json is read from $DOCKER_CONFIG, or from $HOME/.docker.
+	DockerConfig string
+	// Credentials maps registries to credentials, which take precedence over the docker config
+	Credentials map[string]types.DockerAuthConfig
+	// InsecureRegistries are accessed over HTTP, or over HTTPS without verifying their certificates
+	InsecureRegistries []string
+	// Certificates maps registries to a CA certificate, or a directory of certificates, to verify them with
+	Certificates map[string]string
+	// Mirrors maps registries to the mirrors which base images are pulled from, in the order they are tried before the registry.
+	// A mirror is a registry, optionally followed by a path which the repositories it mirrors are beneath.
+	Mirrors map[string][]string
+}
+
+// dockerConfigNames are the names a docker config file may have in a directory.
+// Kubernetes docker-registry secrets are mounted as .dockerconfigjson, or as .dockercfg in the legacy format.
+var dockerConfigNames = []string{"config.json", ".dockerconfigjson", ".dockercfg"}
+
+// defaultCredentialHelpers are the credential helpers used for registries the docker config has no credentials for,
+// provided that they are installed
+var defaultCredentialHelpers = []struct {
+	registry *regexp.Regexp
+	helper   string
+}{
+	{regexp.MustCompile(`^([a-z0-9-]+\.)?gcr\.io$`), "gcr"},
+	{regexp.MustCompile(`^[a-z0-9-]+-docker\.pkg\.dev$`), "gcr"},
+	{regexp.MustCompile(`^[0-9]+\.dkr\.ecr\.[a-z0-9-]+\.amazonaws\.com(\.cn)?$`), "ecr-login"},
+	{regexp.MustCompile(`^[a-z0-9]+\.azurecr\.io$`), "acr-env"},
+}
+
+// credentialHelperDir is where the executor image installs credential helpers, which is searched after PATH
+const credentialHelperDir = "/usr/local/bin"
+
+// certsDir is where a directory is created for each registry given a certificate file
+var certsDir = constants.KanikoCertsDir
+
+// initialEnv is the environment kaniko was started with.
+// Building an image sets the environment of kaniko to that of the image, so the docker config and credential helpers
+// are found with, and credential helpers are run with, the initial environment instead.
+var initialEnv = environMap(os.Environ())
+
+type dockerConfig struct {
+	Auths       map[string]dockerAuth `json:"auths"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+	CredsStore  string                `json:"credsStore"`
+}
+
+type dockerAuth struct {
+	Auth     string `json:"auth"`
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// registryConfig is how each registry is accessed, with the credentials found for each registry cached for the rest of the build
+type registryConfig struct {
+	opts     RegistryOptions
+	config   *dockerConfig
+	cache    map[string]*types.DockerAuthConfig
+	insecure map[string]bool
+	// certDirs maps registries to the directory of certificates used to access them
+	certDirs map[string]string
+	mirrors  map[string][]string
+	// pulls caches the reference each image is pulled from,
+	// so that an image is pulled from the same registry throughout the build even if a mirror becomes unavailable
+	pulls map[string]types.ImageReference
+}
+
+var (
+	registriesMu sync.Mutex
+	// registries is set by SetRegistryOptions, or with the default options when a registry is first accessed
+	registries *registryConfig
+)
+
+// SetRegistryOptions sets the options used to access registries, reading the docker config
+func SetRegistryOptions(opts RegistryOptions) error {
+	r, err := newRegistryConfig(opts)
+	if err != nil {
+		return err
+	}
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	registries = r
+	return nil
+}
+
+// NewSystemContext returns the context used to access registry, with the credentials found for it if there are any
+func NewSystemContext(registry string) (*types.SystemContext, error) {
+	registriesMu.Lock()
+	defer registriesMu.Unlock()
+	r, err := currentRegistries()
+	if err != nil {
+		return nil, err
+	}
+	return r.systemContext(registry)
+}
+
+// ResolveCredentials looks up the credentials for the registries of images, which are then used for the rest of the build
+// This has to be done before the file system is built, which can replace or delete the credential helpers,
+// such as the docker-credential-gcr installed in /usr/local/bin by the executor image.
+func ResolveCredentials(images []string) error {
+	for _, img := range images {
+		ref, err := reference.ParseNormalizedNamed(img)
+		if err != nil {
+			return err
+		}
+		if _, err := NewSystemContext(reference.Domain(ref)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// currentRegistries returns the registry config, with the default options if none have been set
+// The caller must hold registriesMu.
+func currentRegistries() (*registryConfig, error) {
+	if registries == nil {
+		r, err := newRegistryConfig(RegistryOptions{})
+		if err != nil {
+			return nil, err
+		}
+		registries = r
+	}
+	return registries, nil
+}
+
+func (r *registryConfig) systemContext(registry string) (*types.SystemContext, error) {
+	registry = normalizeRegistry(registry)
+	auth, err := r.credentials(registry)
+	if err != nil {
+		return nil, err
+	}
+	return &types.SystemContext{
+		DockerRegistryUserAgent:     fmt.Sprintf("kaniko/executor-%s", version.Version()),
+		DockerAuthConfig:            auth,
+		DockerInsecureSkipTLSVerify: r.insecure[registry],
+		DockerCertPath:              r.certDirs[registry],
+	}, nil
+}
+
+func newRegistryConfig(opts RegistryOptions) (*registryConfig, error) {
+	r := &registryConfig{
+		opts:     opts,
+		cache:    map[string]*types.DockerAuthConfig{},
+		insecure: map[string]bool{},
+		certDirs: map[string]string{},
+		mirrors:  map[string][]string{},
+		pulls:    map[string]types.ImageReference{},
+	}
+	for registry, mirrors := range opts.Mirrors {
+		registry = normalizeRegistry(registry)
+		r.mirrors[registry] = append(r.mirrors[registry], mirrors...)
+	}
+	for _, registry := range opts.InsecureRegistries {
+		r.insecure[normalizeRegistry(registry)] = true
+	}
+	for registry, path := range opts.Certificates {
+		registry = normalizeRegistry(registry)
+		dir, err := certificateDir(registry, path)
+		if err != nil {
+			return nil, err
+		}
+		r.certDirs[registry] = dir
+	}
+	path, err := findDockerConfig(opts.DockerConfig)
+	if err != nil {
+		return nil, err
+	}
+	if path == "" {
+		logrus.Debug("No docker config found")
+		return r, nil
+	}
+	logrus.Infof("Using registry credentials from docker config %s", path)
+	r.config, err = readDockerConfig(path)
+	return r, err
+}
+
+// certificateDir returns the directory of certificates to access registry with, given a certificate or a directory.
+// A directory is used as it is, so it can contain client certificates as well, in the layout of /etc/docker/certs.d/<registry>.
+// A certificate file is linked into a directory of its own, since only directories of certificates can be used.
+func certificateDir(registry, path string) (string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "reading certificate for %s", registry)
+	}
+	if fi.IsDir() {
+		return path, nil
+	}
+	path, err = filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Join(certsDir, registry)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	link := filepath.Join(dir, "ca.crt")
+	if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return dir, os.Symlink(path, link)
+}
+
+// credentials returns the credentials for registry, or nil if it should be accessed anonymously
+func (r *registryConfig) credentials(registry string) (*types.DockerAuthConfig, error) {
+	if auth, ok := r.cache[registry]; ok {
+		return auth, nil
+	}
+	auth, err := r.lookup(registry)
+	if err != nil {
+		return nil, err
+	}
+	r.cache[registry] = auth
+	return auth, nil
+}
+
+// lookup finds the credentials for registry
+// Static credentials are used first, then the docker config, in which a credential helper for the registry
+// takes precedence over the default credential store, which takes precedence over the auths.
+func (r *registryConfig) lookup(registry string) (*types.DockerAuthConfig, error) {
+	for key, auth := range r.opts.Credentials {
+		if normalizeRegistry(key) == registry {
+			return &types.DockerAuthConfig{Username: auth.Username, Password: auth.Password}, nil
+		}
+	}
+	if r.config != nil {
+		for key, helper := range r.config.CredHelpers {
+			if normalizeRegistry(key) == registry {
+				return runCredentialHelper(helper, registry, true)
+			}
+		}
+		if r.config.CredsStore != "" {
+			auth, err := runCredentialHelper(r.config.CredsStore, registry, true)
+			if err != nil || auth != nil {
+				return auth, err
+			}
+		}
+		for key, auth := range r.config.Auths {
+			if normalizeRegistry(key) == registry {
+				return auth.credentials(key)
+			}
+		}
+	}
+	for _, d := range defaultCredentialHelpers {
+		if d.registry.MatchString(registry) {
+			return runCredentialHelper(d.helper, registry, false)
+		}
+	}
+	return nil, nil
+}
+
+// findDockerConfig returns the path of the docker config file to read, or "" if there is none
+// If path is set, it must be a docker config file or a directory containing one.
+func findDockerConfig(path string) (string, error) {
+	if path == "" {
+		dir := initialEnv[constants.DockerConfigEnv]
+		if dir == "" && initialEnv["HOME"] != "" {
+			dir = filepath.Join(initialEnv["HOME"], ".docker")
+		}
+		if dir == "" {
+			dir = constants.DefaultDockerConfigDir
+		}
+		return dockerConfigInDir(dir), nil
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", errors.Wrap(err, "reading docker config")
+	}
+	if !fi.IsDir() {
+		return path, nil
+	}
+	if config := dockerConfigInDir(path); config != "" {
+		return config, nil
+	}
+	return "", errors.Errorf("no docker config found in %s, expected one of %v", path, dockerConfigNames)
+}
+
+func dockerConfigInDir(dir string) string {
+	for _, name := range dockerConfigNames {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+func readDockerConfig(path string) (*dockerConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &dockerConfig{}
+	if err := json.Unmarshal(b, config); err != nil {
+		return nil, errors.Wrapf(err, "parsing docker config %s", path)
+	}
+	if config.Auths == nil && config.CredHelpers == nil && config.CredsStore == "" {
+		// The legacy format only contains the auths
+		var auths map[string]dockerAuth
+		if err := json.Unmarshal(b, &auths); err == nil {
+			config.Auths = auths
+		}
+	}
+	return config, nil
+}
+
+func (a dockerAuth) credentials(registry string) (*types.DockerAuthConfig, error) {
+	if a.Auth == "" {
+		if a.Username == "" {
+			return nil, nil
+		}
+		return &types.DockerAuthConfig{Username: a.Username, Password: a.Password}, nil
+	}
+	decoded, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return nil, errors.Wrapf(err, "decoding auth for %s in docker config", registry)
+	}
+	parts := strings.SplitN(string(decoded), ":", 2)
+	if len(parts) != 2 {
+		return nil, errors.Errorf("auth for %s in docker config should be base64 encoded <username>:<password>", registry)
+	}
+	return &types.DockerAuthConfig{Username: parts[0], Password: parts[1]}, nil
+}
+
+// runCredentialHelper gets the credentials for registry from the credential helper docker-credential-<helper>
+// If the helper isn't installed, it is an error only if required is set.
+func runCredentialHelper(helper, registry string, required bool) (*types.DockerAuthConfig, error) {
+	name := "docker-credential-" + helper
+	path := findCredentialHelper(name)
+	if path == "" {
+		if required {
+			return nil, errors.Errorf("credential helper %s for %s isn't installed", name, registry)
+		}
+		return nil, nil
+	}
+	logrus.Debugf("Getting credentials for %s from %s", registry, path)
+	creds, err := client.Get(client.NewShellProgramFuncWithEnv(path, &initialEnv), helperServerURL(registry))
+	if credentials.IsErrCredentialsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "getting credentials for %s from %s", registry, name)
+	}
+	return &types.DockerAuthConfig{Username: creds.Username, Password: creds.Secret}, nil
+}
+
+// findCredentialHelper returns the path of the executable name in the initial PATH or credentialHelperDir,
+// or "" if it isn't found
+func findCredentialHelper(name string) string {
+	for _, dir := range append(filepath.SplitList(initialEnv["PATH"]), credentialHelperDir) {
+		path := filepath.Join(dir, name)
+		if fi, err := os.Stat(path); err == nil && fi.Mode().IsRegular() && fi.Mode()&0111 != 0 {
+			return path
+		}
+	}
+	return ""
+}
+
+// normalizeRegistry returns the host of a registry, which may be given as a URL in docker configs
+// Docker Hub has several names, which are normalized to docker.io, the domain of its image references.
+func normalizeRegistry(registry string) string {
+	registry = strings.TrimPrefix(strings.TrimPrefix(registry, "https://"), "http://")
+	registry = strings.SplitN(registry, "/", 2)[0]
+	switch registry {
+	case "index.docker.io", "registry-1.docker.io":
+		return "docker.io"
+	}
+	return registry
+}
+
+// helperServerURL returns the server URL credential helpers store the credentials for registry under
+func helperServerURL(registry string) string {
+	if registry == "docker.io" {
+		return "https://index.docker.io/v1/"
+	}
+	return registry
+}
+
+func environMap(environ []string) map[string]string {
+	env := map[string]string{}
+	for _, kv := range environ {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 2 {
+			env[parts[0]] = parts[1]
+		}
+	}
+	return env
+}
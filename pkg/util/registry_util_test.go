@@ -0,0 +1,330 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"encoding/base64"
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"github.com/containers/image/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// credentialHelper prints credentials with the username helper and the server URL as the secret,
+// except for registries named notfound
+var credentialHelper = `#!/bin/sh
+read server || true
+case "$server" in
+notfound*) echo "credentials not found in native keychain"; exit 1 ;;
+esac
+echo "{\"ServerURL\":\"$server\",\"Username\":\"helper\",\"Secret\":\"$server\"}"
+`
+
+func basicAuth(username, password string) string {
+	return base64.StdEncoding.EncodeToString([]byte(username + ":" + password))
+}
+
+var credentialsTests = []struct {
+	name        string
+	configName  string
+	config      string
+	credentials map[string]types.DockerAuthConfig
+	registry    string
+	expected    *types.DockerAuthConfig
+	shouldErr   bool
+}{
+	{
+		name:       "auths in config.json",
+		configName: "config.json",
+		config:     `{"auths": {"gcr.io": {"auth": "` + basicAuth("user", "pass:word") + `"}}}`,
+		registry:   "gcr.io",
+		expected:   &types.DockerAuthConfig{Username: "user", Password: "pass:word"},
+	},
+	{
+		name:       "dockerconfigjson secret",
+		configName: ".dockerconfigjson",
+		config:     `{"auths": {"https://index.docker.io/v1/": {"username": "user", "password": "password"}}}`,
+		registry:   "docker.io",
+		expected:   &types.DockerAuthConfig{Username: "user", Password: "password"},
+	},
+	{
+		name:       "legacy dockercfg",
+		configName: ".dockercfg",
+		config:     `{"https://quay.io/v1/": {"auth": "` + basicAuth("user", "password") + `"}}`,
+		registry:   "quay.io",
+		expected:   &types.DockerAuthConfig{Username: "user", Password: "password"},
+	},
+	{
+		name:       "no credentials for registry",
+		configName: "config.json",
+		config:     `{"auths": {"gcr.io": {"auth": "` + basicAuth("user", "password") + `"}}}`,
+		registry:   "quay.io",
+	},
+	{
+		name:       "invalid auth",
+		configName: "config.json",
+		config:     `{"auths": {"gcr.io": {"auth": "` + base64.StdEncoding.EncodeToString([]byte("user")) + `"}}}`,
+		registry:   "gcr.io",
+		shouldErr:  true,
+	},
+	{
+		name:       "static credentials take precedence",
+		configName: "config.json",
+		config:     `{"auths": {"gcr.io": {"auth": "` + basicAuth("user", "password") + `"}}}`,
+		credentials: map[string]types.DockerAuthConfig{
+			"gcr.io": {Username: "static", Password: "secret"},
+		},
+		registry: "gcr.io",
+		expected: &types.DockerAuthConfig{Username: "static", Password: "secret"},
+	},
+	{
+		name:       "credential helper for registry",
+		configName: "config.json",
+		config:     `{"auths": {"gcr.io": {"auth": "` + basicAuth("user", "password") + `"}}, "credHelpers": {"gcr.io": "test"}}`,
+		registry:   "gcr.io",
+		expected:   &types.DockerAuthConfig{Username: "helper", Password: "gcr.io"},
+	},
+	{
+		name:       "credential store",
+		configName: "config.json",
+		config:     `{"credsStore": "test"}`,
+		registry:   "docker.io",
+		expected:   &types.DockerAuthConfig{Username: "helper", Password: "https://index.docker.io/v1/"},
+	},
+	{
+		name:       "credential store falls back to auths",
+		configName: "config.json",
+		config:     `{"auths": {"notfound.io": {"auth": "` + basicAuth("user", "password") + `"}}, "credsStore": "test"}`,
+		registry:   "notfound.io",
+		expected:   &types.DockerAuthConfig{Username: "user", Password: "password"},
+	},
+	{
+		name:       "missing credential helper",
+		configName: "config.json",
+		config:     `{"credHelpers": {"gcr.io": "missing"}}`,
+		registry:   "gcr.io",
+		shouldErr:  true,
+	},
+	{
+		name:       "default credential helper isn't installed",
+		configName: "config.json",
+		config:     `{}`,
+		registry:   "123456789012.dkr.ecr.us-east-1.amazonaws.com",
+	},
+}
+
+func Test_Credentials(t *testing.T) {
+	helperDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(helperDir)
+	if err := ioutil.WriteFile(filepath.Join(helperDir, "docker-credential-test"), []byte(credentialHelper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	originalPath := initialEnv["PATH"]
+	initialEnv["PATH"] = helperDir
+	defer func() { initialEnv["PATH"] = originalPath }()
+	defer SetRegistryOptions(RegistryOptions{})
+
+	for _, test := range credentialsTests {
+		t.Run(test.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			if err := ioutil.WriteFile(filepath.Join(dir, test.configName), []byte(test.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := SetRegistryOptions(RegistryOptions{DockerConfig: dir, Credentials: test.credentials}); err != nil {
+				t.Fatal(err)
+			}
+			sys, err := NewSystemContext(test.registry)
+			var auth *types.DockerAuthConfig
+			if err == nil {
+				auth = sys.DockerAuthConfig
+			}
+			testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expected, auth)
+		})
+	}
+}
+
+func Test_ResolveCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	helper := filepath.Join(dir, "docker-credential-test")
+	if err := ioutil.WriteFile(helper, []byte(credentialHelper), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"credHelpers": {"gcr.io": "test"}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	originalPath := initialEnv["PATH"]
+	initialEnv["PATH"] = dir
+	defer func() { initialEnv["PATH"] = originalPath }()
+	defer SetRegistryOptions(RegistryOptions{})
+	if err := SetRegistryOptions(RegistryOptions{DockerConfig: dir}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ResolveCredentials([]string{"gcr.io/project/image:latest"}); err != nil {
+		t.Fatal(err)
+	}
+	// The credentials are still used once the helper is deleted, as it may be by the build
+	if err := os.Remove(helper); err != nil {
+		t.Fatal(err)
+	}
+	sys, err := NewSystemContext("gcr.io")
+	testutil.CheckErrorAndDeepEqual(t, false, err, &types.DockerAuthConfig{Username: "helper", Password: "gcr.io"}, sys.DockerAuthConfig)
+	testutil.CheckError(t, true, ResolveCredentials([]string{"gcr.io/Invalid"}))
+}
+
+func Test_SetRegistryOptionsMissingConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	err = SetRegistryOptions(RegistryOptions{DockerConfig: filepath.Join(dir, "config.json")})
+	testutil.CheckError(t, true, err)
+	// A directory without a docker config is an error too
+	err = SetRegistryOptions(RegistryOptions{DockerConfig: dir})
+	testutil.CheckError(t, true, err)
+}
+
+func Test_ImageReferenceCredentials(t *testing.T) {
+	defer SetRegistryOptions(RegistryOptions{})
+	if err := SetRegistryOptions(RegistryOptions{
+		Credentials: map[string]types.DockerAuthConfig{
+			"gcr.io": {Username: "user", Password: "password"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	ref, err := ImageReference("gcr.io/test/image:latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	registryRef, ok := ref.(registryReference)
+	if !ok {
+		t.Fatalf("Expected a registry reference, got %T", ref)
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, &types.DockerAuthConfig{Username: "user", Password: "password"}, registryRef.sys.DockerAuthConfig)
+}
+
+func Test_RegistrySystemContext(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	originalCertsDir := certsDir
+	certsDir = filepath.Join(dir, "certs")
+	defer func() { certsDir = originalCertsDir }()
+	defer SetRegistryOptions(RegistryOptions{})
+
+	cert := filepath.Join(dir, "registry.crt")
+	if err := ioutil.WriteFile(cert, []byte("certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetRegistryOptions(RegistryOptions{
+		InsecureRegistries: []string{"insecure.io:5000"},
+		Certificates: map[string]string{
+			"https://private.io": cert,
+			"certs.io":           dir,
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	sys, err := NewSystemContext("insecure.io:5000")
+	testutil.CheckErrorAndDeepEqual(t, false, err, true, sys.DockerInsecureSkipTLSVerify)
+	testutil.CheckErrorAndDeepEqual(t, false, err, "", sys.DockerCertPath)
+
+	sys, err = NewSystemContext("certs.io")
+	testutil.CheckErrorAndDeepEqual(t, false, err, false, sys.DockerInsecureSkipTLSVerify)
+	testutil.CheckErrorAndDeepEqual(t, false, err, dir, sys.DockerCertPath)
+
+	// A certificate file is linked into a directory of its own
+	sys, err = NewSystemContext("private.io")
+	testutil.CheckErrorAndDeepEqual(t, false, err, filepath.Join(certsDir, "private.io"), sys.DockerCertPath)
+	content, err := ioutil.ReadFile(filepath.Join(sys.DockerCertPath, "ca.crt"))
+	testutil.CheckErrorAndDeepEqual(t, false, err, "certificate", string(content))
+
+	err = SetRegistryOptions(RegistryOptions{Certificates: map[string]string{"missing.io": filepath.Join(dir, "missing.crt")}})
+	testutil.CheckError(t, true, err)
+}
+
+func Test_ImageReferenceMirror(t *testing.T) {
+	origin := testutil.NewRegistry()
+	defer origin.Close()
+	mirror := testutil.NewRegistry()
+	defer mirror.Close()
+	unreachable := testutil.NewRegistry()
+	unreachable.Close()
+	manifest := []byte(`{"schemaVersion": 2, "mediaType": "application/vnd.docker.distribution.manifest.v2+json"}`)
+	origin.AddManifest("test/image", "v1", manifest, "application/vnd.docker.distribution.manifest.v2+json")
+	mirror.AddManifest("cache/test/image", "v1", manifest, "application/vnd.docker.distribution.manifest.v2+json")
+
+	defer SetRegistryOptions(RegistryOptions{})
+	var tests = []struct {
+		name     string
+		img      string
+		mirrors  []string
+		expected string
+	}{
+		{
+			name:     "image in mirror",
+			img:      origin.Host() + "/test/image:v1",
+			mirrors:  []string{unreachable.Host(), "http://" + mirror.Host() + "/cache/"},
+			expected: mirror.Host() + "/cache/test/image:v1",
+		},
+		{
+			name:     "image not in mirror",
+			img:      origin.Host() + "/test/image:v1",
+			mirrors:  []string{mirror.Host()},
+			expected: origin.Host() + "/test/image:v1",
+		},
+		{
+			name:     "no mirrors",
+			img:      origin.Host() + "/test/image:v1",
+			expected: origin.Host() + "/test/image:v1",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := SetRegistryOptions(RegistryOptions{
+				InsecureRegistries: []string{origin.Host(), mirror.Host(), unreachable.Host()},
+				Mirrors:            map[string][]string{origin.Host(): test.mirrors},
+			}); err != nil {
+				t.Fatal(err)
+			}
+			ref, err := ImageReference(test.img)
+			var pulled string
+			if err == nil {
+				pulled = ref.DockerReference().String()
+			}
+			testutil.CheckErrorAndDeepEqual(t, false, err, test.expected, pulled)
+		})
+	}
+}
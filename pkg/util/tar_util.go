@@ -21,17 +21,44 @@ import (
 	"compress/bzip2"
 	"compress/gzip"
 	pkgutil "github.com/GoogleCloudPlatform/container-diff/pkg/util"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
 	"github.com/docker/docker/pkg/archive"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 )
 
 var hardlinks = make(map[uint64]string)
 
+// reproducibleTime is the timestamp given to every file added to a layer, if the build is reproducible
+var reproducibleTime *time.Time
+
+// SetReproducibleTime makes every file added to a layer have the timestamp t, so that layers don't depend on
+// when their files were written; if t is nil, files keep their own timestamps
+func SetReproducibleTime(t *time.Time) {
+	reproducibleTime = t
+}
+
+// SourceDateEpoch returns the time set by the SOURCE_DATE_EPOCH environment variable, or the epoch if it isn't set
+func SourceDateEpoch() (time.Time, error) {
+	epoch := os.Getenv(constants.SourceDateEpoch)
+	if epoch == "" {
+		return time.Unix(0, 0).UTC(), nil
+	}
+	seconds, err := strconv.ParseInt(epoch, 10, 64)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "invalid %s %s", constants.SourceDateEpoch, epoch)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
 // AddToTar adds the file i to tar w at path p
 func AddToTar(p string, i os.FileInfo, w *tar.Writer) error {
 	linkDst := ""
@@ -47,6 +74,14 @@ func AddToTar(p string, i os.FileInfo, w *tar.Writer) error {
 		return err
 	}
 	hdr.Name = p
+	// Ownership is recorded by id only, since names would be looked up in the executor's /etc/passwd and /etc/group
+	if stat, ok := i.Sys().(*syscall.Stat_t); ok {
+		hdr.Uid = int(stat.Uid)
+		hdr.Gid = int(stat.Gid)
+	}
+	hdr.Uname = ""
+	hdr.Gname = ""
+	setReproducibleTime(hdr)
 
 	hardlink, linkDst := checkHardlink(p, i)
 	if hardlink {
@@ -71,6 +106,33 @@ func AddToTar(p string, i os.FileInfo, w *tar.Writer) error {
 	return nil
 }
 
+// AddWhiteoutToTar adds a whiteout file to tar w, marking path p as deleted
+func AddWhiteoutToTar(p string, w *tar.Writer) error {
+	name := filepath.Join(filepath.Dir(p), constants.WhiteoutPrefix+filepath.Base(p))
+	logrus.Debugf("Adding whiteout %s for deleted path %s", name, p)
+	return addEmptyFileToTar(name, w)
+}
+
+func addEmptyFileToTar(name string, w *tar.Writer) error {
+	hdr := &tar.Header{
+		Name:     name,
+		Typeflag: tar.TypeReg,
+		Mode:     0644,
+		Size:     0,
+	}
+	setReproducibleTime(hdr)
+	return w.WriteHeader(hdr)
+}
+
+func setReproducibleTime(hdr *tar.Header) {
+	if reproducibleTime == nil {
+		return
+	}
+	hdr.ModTime = *reproducibleTime
+	hdr.AccessTime = time.Time{}
+	hdr.ChangeTime = time.Time{}
+}
+
 // Returns true if path is hardlink, and the link destination
 func checkHardlink(p string, i os.FileInfo) (bool, string) {
 	hardlink := false
@@ -96,29 +158,68 @@ func checkHardlink(p string, i os.FileInfo) (bool, string) {
 // UnpackLocalTarArchive unpacks the tar archive at path to the directory dest
 // Returns true if the path was acutally unpacked
 func UnpackLocalTarArchive(path, dest string) error {
+	r, err := openLocalTarArchive(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return pkgutil.UnTar(r, dest, nil)
+}
+
+// LocalTarArchiveFiles returns the paths the files in the tar archive at path are unpacked to in dest
+func LocalTarArchiveFiles(path, dest string) ([]string, error) {
+	r, err := openLocalTarArchive(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var files []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasPrefix(filepath.Base(hdr.Name), constants.WhiteoutPrefix) {
+			continue
+		}
+		files = append(files, filepath.Join(dest, hdr.Name))
+	}
+}
+
+// openLocalTarArchive returns a reader for the uncompressed contents of the tar archive at path
+func openLocalTarArchive(path string) (io.ReadCloser, error) {
 	// First, we need to check if the path is a local tar archive
 	if compressed, compressionLevel := fileIsCompressedTar(path); compressed {
 		file, err := os.Open(path)
 		if err != nil {
-			return err
+			return nil, err
 		}
-		defer file.Close()
 		if compressionLevel == archive.Gzip {
-			return UnpackCompressedTar(path, dest)
+			gzr, err := gzip.NewReader(file)
+			if err != nil {
+				file.Close()
+				return nil, err
+			}
+			return readCloser{gzr, file}, nil
 		} else if compressionLevel == archive.Bzip2 {
-			bzr := bzip2.NewReader(file)
-			return pkgutil.UnTar(bzr, dest, nil)
+			return readCloser{bzip2.NewReader(file), file}, nil
 		}
+		file.Close()
 	}
 	if fileIsUncompressedTar(path) {
-		file, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		defer file.Close()
-		return pkgutil.UnTar(file, dest, nil)
+		return os.Open(path)
 	}
-	return errors.New("path does not lead to local tar archive")
+	return nil, errors.New("path does not lead to local tar archive")
+}
+
+// readCloser reads from a decompressing reader, and closes the file it reads from
+type readCloser struct {
+	io.Reader
+	io.Closer
 }
 
 //IsFileLocalTarArchive returns true if the file is a local tar archive
@@ -18,13 +18,17 @@ package util
 
 import (
 	"archive/tar"
+	"bytes"
 	"compress/gzip"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
 	"github.com/GoogleCloudPlatform/kaniko/testutil"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"syscall"
 	"testing"
+	"time"
 )
 
 var regularFiles = []string{"file", "file.tar", "file.tar.gz"}
@@ -57,6 +61,59 @@ func Test_IsLocalTarArchive(t *testing.T) {
 	}
 }
 
+func Test_LocalTarArchiveFiles(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("err setting up temp dir: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	if err := setUpFilesAndTars(testDir); err != nil {
+		t.Fatal(err)
+	}
+	var expectedFiles []string
+	for _, regularFile := range regularFiles {
+		expectedFiles = append(expectedFiles, filepath.Join("/dest", testDir, regularFile))
+	}
+	for _, uncompressedTar := range uncompressedTars {
+		files, err := LocalTarArchiveFiles(filepath.Join(testDir, uncompressedTar), "/dest")
+		testutil.CheckErrorAndDeepEqual(t, false, err, expectedFiles, files)
+	}
+}
+
+func Test_AddToTarOwnership(t *testing.T) {
+	testDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("err setting up temp dir: %v", err)
+	}
+	defer os.RemoveAll(testDir)
+	path := filepath.Join(testDir, "file")
+	if err := ioutil.WriteFile(path, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if os.Getuid() == 0 {
+		if err := os.Lchown(path, 1000, 1001); err != nil {
+			t.Fatal(err)
+		}
+	}
+	fi, err := os.Lstat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer([]byte{})
+	w := tar.NewWriter(buf)
+	if err := AddToTar(path, fi, w); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	hdr, err := tar.NewReader(buf).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stat := fi.Sys().(*syscall.Stat_t)
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []int{int(stat.Uid), int(stat.Gid)}, []int{hdr.Uid, hdr.Gid})
+	testutil.CheckErrorAndDeepEqual(t, false, nil, []string{"", ""}, []string{hdr.Uname, hdr.Gname})
+}
+
 func setUpFilesAndTars(testDir string) error {
 	regularFilesAndContents := map[string]string{
 		regularFiles[0]: "",
@@ -106,3 +163,30 @@ func createTar(testdir string, writer io.Writer) error {
 	}
 	return nil
 }
+
+func Test_SourceDateEpoch(t *testing.T) {
+	defer os.Unsetenv(constants.SourceDateEpoch)
+	tests := []struct {
+		epoch       string
+		expected    time.Time
+		shouldError bool
+	}{
+		{
+			epoch:    "",
+			expected: time.Unix(0, 0).UTC(),
+		},
+		{
+			epoch:    "1528761600",
+			expected: time.Date(2018, time.June, 12, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			epoch:       "yesterday",
+			shouldError: true,
+		},
+	}
+	for _, test := range tests {
+		os.Setenv(constants.SourceDateEpoch, test.epoch)
+		actual, err := SourceDateEpoch()
+		testutil.CheckErrorAndDeepEqual(t, test.shouldError, err, test.expected, actual)
+	}
+}
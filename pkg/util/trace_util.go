@@ -0,0 +1,111 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tracedPaths are the paths passed to syscalls which change files, recorded while tracing a command
+type tracedPaths struct {
+	// paths maps each path to whether everything beneath it may have changed as well,
+	// which is the case for the destination of a rename
+	paths map[string]bool
+	// failed is set if a syscall couldn't be decoded, in which case the paths are incomplete
+	failed bool
+}
+
+func newTracedPaths() *tracedPaths {
+	return &tracedPaths{paths: map[string]bool{}}
+}
+
+func (t *tracedPaths) add(path string, subtree bool) {
+	t.paths[path] = t.paths[path] || subtree
+}
+
+// files returns the sorted paths of every file which may have been created, changed or deleted
+// Symlinks in the parent directories of each path are resolved, since it is the file they lead to which changed.
+func (t *tracedPaths) files() []string {
+	files := map[string]struct{}{}
+	for p, subtree := range t.paths {
+		p = resolveParentDir(filepath.Clean(p))
+		files[p] = struct{}{}
+		if !subtree {
+			continue
+		}
+		filepath.Walk(p, func(path string, info os.FileInfo, err error) error {
+			if err == nil {
+				files[path] = struct{}{}
+			}
+			return nil
+		})
+	}
+	paths := []string{}
+	for p := range files {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+func resolveParentDir(p string) string {
+	dir, err := filepath.EvalSymlinks(filepath.Dir(p))
+	if err != nil {
+		return p
+	}
+	return filepath.Join(dir, filepath.Base(p))
+}
+
+// RunTraced runs cmd under ptrace, recording the files which it and its children create, change or delete
+// It returns the paths of those files, or nil if the command couldn't be traced, in which case any file may have changed.
+// As with docker build, processes which the command leaves running in the background are killed when it exits.
+func RunTraced(cmd *exec.Cmd) ([]string, error) {
+	traced := newTracedPaths()
+	started, err := traceCommand(cmd, traced)
+	if !started {
+		logrus.Warnf("Unable to trace %v, running it without tracing: %s", cmd.Args, err)
+		return nil, untracedCommand(cmd).Run()
+	}
+	if traced.failed {
+		logrus.Warnf("Tracing %v failed, the full filesystem will be snapshotted", cmd.Args)
+		return nil, err
+	}
+	files := traced.files()
+	logrus.Debugf("Traced changes to %v", files)
+	return files, err
+}
+
+// untracedCommand returns a copy of cmd which doesn't start under ptrace, since a command can't be started twice
+func untracedCommand(cmd *exec.Cmd) *exec.Cmd {
+	untraced := exec.Command(cmd.Path, cmd.Args[1:]...)
+	untraced.Dir = cmd.Dir
+	untraced.Env = cmd.Env
+	untraced.Stdin = cmd.Stdin
+	untraced.Stdout = cmd.Stdout
+	untraced.Stderr = cmd.Stderr
+	if cmd.SysProcAttr != nil {
+		attr := *cmd.SysProcAttr
+		attr.Ptrace = false
+		untraced.SysProcAttr = &attr
+	}
+	return untraced
+}
@@ -0,0 +1,341 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// Syscalls and constants missing from the syscall package
+const (
+	sysRenameat2 = 316
+	sysOpenat2   = 437
+	sysFchmodat2 = 452
+	// atFDCWD is the directory file descriptor which refers to the working directory
+	atFDCWD = -100
+)
+
+const (
+	traceOptions = syscall.PTRACE_O_TRACESYSGOOD | syscall.PTRACE_O_TRACEFORK | syscall.PTRACE_O_TRACEVFORK |
+		syscall.PTRACE_O_TRACECLONE | syscall.PTRACE_O_TRACEEXEC | syscall.PTRACE_O_TRACEEXIT
+	// syscallTrap is the stop signal of a syscall stop, when PTRACE_O_TRACESYSGOOD is set
+	syscallTrap = syscall.SIGTRAP | 0x80
+	// writeFlags are the flags to open which can change a file
+	writeFlags = syscall.O_WRONLY | syscall.O_RDWR | syscall.O_CREAT | syscall.O_TRUNC
+	// maxPathLen is the longest path the kernel accepts, including the terminating null byte
+	maxPathLen = 4096
+	// waitOptions make wait only return the children of the calling thread, which are the command and its tracees,
+	// and not the processes started by other goroutines of the executor
+	waitOptions = syscall.WALL | syscall.WNOTHREAD
+)
+
+// traceCommand starts cmd under ptrace, and adds the paths it passes to syscalls which change files to traced until it exits
+// Returns whether cmd was started, and the error it exited with.
+func traceCommand(cmd *exec.Cmd, traced *tracedPaths) (bool, error) {
+	// Every ptrace request has to come from the thread which started the command
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Ptrace = true
+	if err := cmd.Start(); err != nil {
+		return false, err
+	}
+	pid := cmd.Process.Pid
+	// The command stops once it has been exec'd
+	var ws syscall.WaitStatus
+	if _, err := syscall.Wait4(pid, &ws, waitOptions, nil); err != nil {
+		traced.failed = true
+		return true, cmd.Wait()
+	}
+	if err := syscall.PtraceSetOptions(pid, traceOptions); err != nil {
+		logrus.Debugf("Error setting ptrace options: %s", err)
+		traced.failed = true
+		syscall.PtraceDetach(pid)
+		return true, cmd.Wait()
+	}
+	t := &tracer{
+		pid:     pid,
+		traced:  traced,
+		tracees: map[int]bool{pid: true},
+	}
+	return true, t.run(cmd)
+}
+
+// tracer follows a command and the processes and threads it creates
+type tracer struct {
+	pid    int
+	traced *tracedPaths
+	// tracees maps each process and thread being traced to whether it has stopped for the first time
+	// They are added when they are created, so that they are killed with the command even if they haven't stopped yet.
+	tracees map[int]bool
+}
+
+func (t *tracer) run(cmd *exec.Cmd) error {
+	t.resume(t.pid, 0)
+	for {
+		var ws syscall.WaitStatus
+		wpid, err := syscall.Wait4(-1, &ws, waitOptions, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			logrus.Debugf("Error waiting for traced processes: %s", err)
+			t.traced.failed = true
+			break
+		}
+		if ws.Exited() || ws.Signaled() {
+			delete(t.tracees, wpid)
+			if wpid == t.pid {
+				// The command was reaped here, so cmd.Wait can't get its status, but it still has to be called
+				// to finish copying the command's output and close the pipes
+				t.killTracees()
+				cmd.Wait()
+				return exitError(ws)
+			}
+			continue
+		}
+		if !ws.Stopped() {
+			continue
+		}
+		sig := ws.StopSignal()
+		switch {
+		case sig == syscallTrap:
+			t.syscallStop(wpid)
+			t.resume(wpid, 0)
+		case sig == syscall.SIGTRAP && ws.TrapCause() == syscall.PTRACE_EVENT_EXIT && wpid == t.pid:
+			// Let the command exit on its own, so that cmd.Wait gets its status
+			syscall.PtraceDetach(wpid)
+			delete(t.tracees, wpid)
+			t.killTracees()
+			return cmd.Wait()
+		case sig == syscall.SIGTRAP && ws.TrapCause() != 0:
+			// New processes and threads are traced automatically, and stop with SIGSTOP before they first run
+			t.addTracee(wpid, ws.TrapCause())
+			t.resume(wpid, 0)
+		case sig == syscall.SIGSTOP && !t.tracees[wpid]:
+			t.tracees[wpid] = true
+			t.resume(wpid, 0)
+		default:
+			// Deliver any other signal to the tracee
+			t.resume(wpid, int(sig))
+		}
+	}
+	syscall.PtraceDetach(t.pid)
+	return cmd.Wait()
+}
+
+func (t *tracer) resume(pid int, sig int) {
+	if err := syscall.PtraceSyscall(pid, sig); err != nil && err != syscall.ESRCH {
+		logrus.Debugf("Error resuming traced process %d: %s", pid, err)
+		t.traced.failed = true
+	}
+}
+
+// addTracee records the process or thread created by pid, if cause is the event of a fork, vfork or clone
+// Its first stop may already have been seen, since it can stop before the event is reported.
+func (t *tracer) addTracee(pid int, cause int) {
+	switch cause {
+	case syscall.PTRACE_EVENT_FORK, syscall.PTRACE_EVENT_VFORK, syscall.PTRACE_EVENT_CLONE:
+	default:
+		return
+	}
+	child, err := syscall.PtraceGetEventMsg(pid)
+	if err != nil {
+		logrus.Debugf("Error getting the process created by traced process %d: %s", pid, err)
+		t.traced.failed = true
+		return
+	}
+	if _, ok := t.tracees[int(child)]; !ok {
+		t.tracees[int(child)] = false
+	}
+}
+
+// killTracees kills the processes started by the command which are still running once it has exited
+func (t *tracer) killTracees() {
+	for pid := range t.tracees {
+		syscall.Kill(pid, syscall.SIGKILL)
+	}
+	for pid := range t.tracees {
+		for {
+			var ws syscall.WaitStatus
+			_, err := syscall.Wait4(pid, &ws, waitOptions, nil)
+			if err == syscall.EINTR {
+				continue
+			}
+			if err != nil || ws.Exited() || ws.Signaled() {
+				break
+			}
+			// Killed tracees still report the stops they were already in, and stop at PTRACE_EVENT_EXIT,
+			// so they have to be resumed until they exit
+			syscall.PtraceCont(pid, 0)
+		}
+	}
+}
+
+func exitError(ws syscall.WaitStatus) error {
+	if ws.Signaled() {
+		return errors.Errorf("command was killed by signal %s", ws.Signal())
+	}
+	if ws.ExitStatus() != 0 {
+		return errors.Errorf("exit status %d", ws.ExitStatus())
+	}
+	return nil
+}
+
+// syscallStop records the paths changed by the syscall a tracee has stopped at
+// Paths are read when the syscall is entered, since renames and deletions change what they refer to.
+func (t *tracer) syscallStop(pid int) {
+	var regs syscall.PtraceRegs
+	if err := syscall.PtraceGetRegs(pid, &regs); err != nil {
+		if err != syscall.ESRCH {
+			logrus.Debugf("Error reading registers of traced process %d: %s", pid, err)
+			t.traced.failed = true
+		}
+		return
+	}
+	// rax is set to -ENOSYS when a syscall is entered, and to the return value when it exits
+	if int64(regs.Rax) != -int64(syscall.ENOSYS) {
+		return
+	}
+	args := []uint64{regs.Rdi, regs.Rsi, regs.Rdx, regs.R10, regs.R8, regs.R9}
+	// path records the path argument at index arg, relative to the directory file descriptor at index dirfd,
+	// or the working directory if dirfd is -1
+	path := func(dirfd, arg int, subtree bool) {
+		fd := atFDCWD
+		if dirfd >= 0 {
+			fd = int(int32(args[dirfd]))
+		}
+		t.addPath(pid, fd, uintptr(args[arg]), subtree)
+	}
+	fdPath := func(arg int) {
+		t.addPath(pid, int(int32(args[arg])), 0, false)
+	}
+
+	switch regs.Orig_rax {
+	case syscall.SYS_OPEN:
+		if args[1]&writeFlags != 0 {
+			path(-1, 0, false)
+		}
+	case syscall.SYS_OPENAT:
+		if args[2]&writeFlags != 0 {
+			path(0, 1, false)
+		}
+	case sysOpenat2:
+		// The flags are the first field of struct open_how
+		b := make([]byte, 8)
+		if _, err := syscall.PtracePeekData(pid, uintptr(args[2]), b); err != nil {
+			t.traced.failed = true
+			return
+		}
+		if binary.LittleEndian.Uint64(b)&writeFlags != 0 {
+			path(0, 1, false)
+		}
+	case syscall.SYS_CREAT, syscall.SYS_TRUNCATE, syscall.SYS_UNLINK, syscall.SYS_RMDIR, syscall.SYS_MKDIR,
+		syscall.SYS_MKNOD, syscall.SYS_CHMOD, syscall.SYS_CHOWN, syscall.SYS_LCHOWN, syscall.SYS_UTIME, syscall.SYS_UTIMES,
+		syscall.SYS_SETXATTR, syscall.SYS_LSETXATTR, syscall.SYS_REMOVEXATTR, syscall.SYS_LREMOVEXATTR:
+		path(-1, 0, false)
+	case syscall.SYS_UNLINKAT, syscall.SYS_MKDIRAT, syscall.SYS_MKNODAT, syscall.SYS_FCHMODAT, sysFchmodat2,
+		syscall.SYS_FCHOWNAT, syscall.SYS_UTIMENSAT, syscall.SYS_FUTIMESAT:
+		path(0, 1, false)
+	case syscall.SYS_FCHMOD, syscall.SYS_FCHOWN, syscall.SYS_FTRUNCATE, syscall.SYS_FSETXATTR, syscall.SYS_FREMOVEXATTR:
+		fdPath(0)
+	case syscall.SYS_RENAME:
+		path(-1, 0, false)
+		path(-1, 1, true)
+	case syscall.SYS_RENAMEAT, sysRenameat2:
+		path(0, 1, false)
+		path(2, 3, true)
+	case syscall.SYS_LINK:
+		path(-1, 0, false)
+		path(-1, 1, false)
+	case syscall.SYS_LINKAT:
+		path(0, 1, false)
+		path(2, 3, false)
+	case syscall.SYS_SYMLINK:
+		path(-1, 1, false)
+	case syscall.SYS_SYMLINKAT:
+		path(1, 2, false)
+	}
+}
+
+// addPath records the path at addr in the memory of the tracee, relative to the directory dirfd refers to
+// If addr is 0, or the path is empty, the file dirfd refers to is recorded.
+func (t *tracer) addPath(pid, dirfd int, addr uintptr, subtree bool) {
+	var p string
+	if addr != 0 {
+		var err error
+		p, err = readString(pid, addr)
+		if err != nil {
+			logrus.Debugf("Error reading path from traced process %d: %s", pid, err)
+			t.traced.failed = true
+			return
+		}
+	}
+	if filepath.IsAbs(p) {
+		t.traced.add(p, subtree)
+		return
+	}
+	dir := fmt.Sprintf("/proc/%d/cwd", pid)
+	if dirfd != atFDCWD {
+		dir = fmt.Sprintf("/proc/%d/fd/%d", pid, dirfd)
+	}
+	resolved, err := os.Readlink(dir)
+	if err != nil {
+		if dirfd == atFDCWD {
+			logrus.Debugf("Error reading working directory of traced process %d: %s", pid, err)
+			t.traced.failed = true
+		}
+		// Otherwise the file descriptor is invalid, so the syscall will fail
+		return
+	}
+	// Pipes and sockets aren't files
+	if !filepath.IsAbs(resolved) {
+		return
+	}
+	t.traced.add(filepath.Join(resolved, p), subtree)
+}
+
+// readString reads a null terminated string from the memory of the tracee at addr
+func readString(pid int, addr uintptr) (string, error) {
+	var s []byte
+	chunk := make([]byte, 256)
+	for len(s) < maxPathLen {
+		// Reading stops at the first unmapped address, which may be after the end of the string
+		n, err := syscall.PtracePeekData(pid, addr+uintptr(len(s)), chunk)
+		if i := bytes.IndexByte(chunk[:n], 0); i >= 0 {
+			return string(append(s, chunk[:i]...)), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		s = append(s, chunk[:n]...)
+	}
+	return "", errors.Errorf("path at %x is longer than %d bytes", addr, maxPathLen)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package util
+
+import (
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func Test_RunTraced(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("tracing is only supported on linux/amd64")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	// Resolve the temp dir, since traced paths are resolved
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.SetupFiles(dir, map[string]string{
+		"unchanged": "unchanged",
+		"deleted":   "deleted",
+		"moved":     "moved",
+		"chmod":     "chmod",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	script := `cat unchanged
+echo new > new
+rm deleted
+mkdir -p dir/sub
+(echo child > dir/sub/file) &
+wait
+mv dir renamed
+mv moved renamed/moved
+chmod 600 chmod
+ln -s new link`
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Dir = dir
+	files, err := RunTraced(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if files == nil {
+		t.Skip("tracing isn't permitted in this environment")
+	}
+	expected := []string{
+		filepath.Join(dir, "chmod"),
+		filepath.Join(dir, "deleted"),
+		filepath.Join(dir, "dir"),
+		filepath.Join(dir, "dir/sub"),
+		filepath.Join(dir, "dir/sub/file"),
+		filepath.Join(dir, "link"),
+		filepath.Join(dir, "moved"),
+		filepath.Join(dir, "new"),
+		filepath.Join(dir, "renamed"),
+		filepath.Join(dir, "renamed/moved"),
+		filepath.Join(dir, "renamed/sub"),
+		filepath.Join(dir, "renamed/sub/file"),
+	}
+	testutil.CheckErrorAndDeepEqual(t, false, nil, expected, files)
+}
+
+func Test_RunTracedExitStatus(t *testing.T) {
+	files, err := RunTraced(exec.Command("/bin/sh", "-c", "cat /etc/passwd; exit 3"))
+	testutil.CheckError(t, true, err)
+	// Nothing was changed, so if the command was traced no files should be returned
+	if files != nil && len(files) != 0 {
+		t.Errorf("Expected no files to be traced, got %v", files)
+	}
+}
+
+func Test_RunTracedKillsBackgroundProcesses(t *testing.T) {
+	if runtime.GOOS != "linux" || runtime.GOARCH != "amd64" {
+		t.Skip("tracing is only supported on linux/amd64")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	pidsFile := filepath.Join(dir, "pids")
+	// The command exits as soon as it has started the processes, which may not have stopped for the first time yet
+	script := `for i in 1 2 3 4 5 6 7 8 9 10; do sleep 100 & echo $! >> ` + pidsFile + `; done; exit 1`
+	files, err := RunTraced(exec.Command("/bin/sh", "-c", script))
+	testutil.CheckError(t, true, err)
+	if files == nil {
+		t.Skip("tracing isn't permitted in this environment")
+	}
+	pids, err := ioutil.ReadFile(pidsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range strings.Fields(string(pids)) {
+		// Killed processes may be left as zombies, since their parent has exited
+		stat, err := ioutil.ReadFile(filepath.Join("/proc", pid, "stat"))
+		if err == nil && !strings.Contains(string(stat), ") Z ") {
+			t.Errorf("Expected background process %s to have been killed: %s", pid, stat)
+		}
+	}
+}
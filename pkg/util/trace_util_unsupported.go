@@ -0,0 +1,32 @@
+//go:build !linux || !amd64
+// +build !linux !amd64
+
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"os/exec"
+	"runtime"
+
+	"github.com/pkg/errors"
+)
+
+// traceCommand never starts cmd, since decoding syscalls is only implemented for linux/amd64
+func traceCommand(cmd *exec.Cmd, traced *tracedPaths) (bool, error) {
+	return false, errors.Errorf("tracing isn't supported on %s/%s", runtime.GOOS, runtime.GOARCH)
+}
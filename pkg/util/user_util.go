@@ -0,0 +1,214 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	passwdPath = "/etc/passwd"
+	groupPath  = "/etc/group"
+)
+
+// User is an entry in /etc/passwd
+type User struct {
+	Name string
+	UID  int
+	GID  int
+	Home string
+}
+
+// LookupUser finds the user with the given name or uid in the /etc/passwd file of the image at root
+// The executor's own users are never used, since they may differ from the image's.
+func LookupUser(nameOrUID, root string) (*User, error) {
+	var user *User
+	err := readColonFile(filepath.Join(root, passwdPath), func(fields []string) bool {
+		// name:password:uid:gid:gecos:home:shell
+		if len(fields) < 6 || (fields[0] != nameOrUID && fields[2] != nameOrUID) {
+			return false
+		}
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return false
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			return false
+		}
+		user = &User{
+			Name: fields[0],
+			UID:  uid,
+			GID:  gid,
+			Home: fields[5],
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, errors.Errorf("unable to find user %s in %s", nameOrUID, passwdPath)
+	}
+	return user, nil
+}
+
+// LookupGroup returns the gid of the group with the given name or gid in the /etc/group file of the image at root
+func LookupGroup(nameOrGID, root string) (int, error) {
+	gid := -1
+	err := readColonFile(filepath.Join(root, groupPath), func(fields []string) bool {
+		// name:password:gid:members
+		if len(fields) < 3 || (fields[0] != nameOrGID && fields[2] != nameOrGID) {
+			return false
+		}
+		id, err := strconv.Atoi(fields[2])
+		if err != nil {
+			return false
+		}
+		gid = id
+		return true
+	})
+	if err != nil {
+		return -1, err
+	}
+	if gid == -1 {
+		return -1, errors.Errorf("unable to find group %s in %s", nameOrGID, groupPath)
+	}
+	return gid, nil
+}
+
+// GetUIDAndGID resolves a user and optional group in the form user[:group], as given to --chown,
+// to a numeric uid and gid
+// Names are resolved in the image at root by LookupExecUser, as they are for USER. If no group is given, a numeric
+// user's uid is used as the gid as well, as with docker build, and a named user's group is the group with the same
+// name, or the user's primary group if there is none.
+func GetUIDAndGID(userAndGroup, root string) (int, int, error) {
+	execUser, err := LookupExecUser(userAndGroup, root)
+	if err != nil {
+		return -1, -1, err
+	}
+	if strings.Contains(userAndGroup, ":") {
+		return execUser.UID, execUser.GID, nil
+	}
+	if _, err := strconv.Atoi(userAndGroup); err == nil {
+		return execUser.UID, execUser.UID, nil
+	}
+	if gid, err := LookupGroup(userAndGroup, root); err == nil {
+		return execUser.UID, gid, nil
+	}
+	return execUser.UID, execUser.GID, nil
+}
+
+// ExecUser is the user a command runs as
+type ExecUser struct {
+	UID int
+	GID int
+	// Groups are the supplementary groups of the user, which it has unless a group is given
+	Groups []int
+	Home   string
+}
+
+// LookupExecUser resolves a user and optional group in the form user[:group], as set by USER, to the user a command runs as.
+// As with docker build, names are looked up in the image at root when the command runs, and the user's primary group,
+// supplementary groups and home directory come from the image too. A uid which isn't in /etc/passwd is used as it is,
+// with the gid 0 and the home directory /.
+func LookupExecUser(userAndGroup, root string) (*ExecUser, error) {
+	parts := strings.Split(userAndGroup, ":")
+	if len(parts) > 2 {
+		return nil, errors.Errorf("invalid user and group %s, should be of the form user[:group]", userAndGroup)
+	}
+	execUser := &ExecUser{Home: "/"}
+	user, err := LookupUser(parts[0], root)
+	if err == nil {
+		execUser.UID, execUser.GID = user.UID, user.GID
+		if user.Home != "" {
+			execUser.Home = user.Home
+		}
+	} else if uid, atoiErr := strconv.Atoi(parts[0]); atoiErr == nil {
+		execUser.UID = uid
+	} else {
+		return nil, err
+	}
+	if len(parts) == 2 {
+		gid, err := strconv.Atoi(parts[1])
+		if err != nil {
+			if gid, err = LookupGroup(parts[1], root); err != nil {
+				return nil, err
+			}
+		}
+		execUser.GID = gid
+		return execUser, nil
+	}
+	if user != nil {
+		if execUser.Groups, err = lookupSupplementaryGroups(user.Name, root); err != nil {
+			return nil, err
+		}
+	}
+	return execUser, nil
+}
+
+// lookupSupplementaryGroups returns the gids of the groups which list the user as a member in the /etc/group file of the image at root
+func lookupSupplementaryGroups(name, root string) ([]int, error) {
+	var gids []int
+	err := readColonFile(filepath.Join(root, groupPath), func(fields []string) bool {
+		// name:password:gid:members
+		if len(fields) < 4 {
+			return false
+		}
+		for _, member := range strings.Split(fields[3], ",") {
+			if member != name {
+				continue
+			}
+			if gid, err := strconv.Atoi(fields[2]); err == nil {
+				gids = append(gids, gid)
+			}
+			break
+		}
+		return false
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return gids, nil
+}
+
+// readColonFile calls match with the fields of each line of a colon separated file like /etc/passwd,
+// until it returns true
+func readColonFile(path string, match func([]string) bool) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if match(strings.Split(line, ":")) {
+			return nil
+		}
+	}
+	return scanner.Err()
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package util
+
+import (
+	"github.com/GoogleCloudPlatform/kaniko/testutil"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var userFiles = map[string]string{
+	"etc/passwd": `root:x:0:0:root:/root:/bin/sh
+# comment
+app:x:1000:1001:app user:/home/app:/bin/sh
+postgres:x:1002:1001::/var/lib/postgresql:/bin/sh
+`,
+	"etc/group": `root:x:0:
+app:x:1001:
+staff:x:50:app
+`,
+}
+
+func setUpUserFiles(t *testing.T) string {
+	root, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("err setting up temp dir: %v", err)
+	}
+	if err := testutil.SetupFiles(root, userFiles); err != nil {
+		t.Fatalf("err setting up files: %v", err)
+	}
+	return root
+}
+
+func Test_LookupUser(t *testing.T) {
+	root := setUpUserFiles(t)
+	defer os.RemoveAll(root)
+	tests := []struct {
+		user         string
+		expectedUser *User
+		shouldErr    bool
+	}{
+		{
+			user:         "app",
+			expectedUser: &User{Name: "app", UID: 1000, GID: 1001, Home: "/home/app"},
+		},
+		{
+			user:         "1000",
+			expectedUser: &User{Name: "app", UID: 1000, GID: 1001, Home: "/home/app"},
+		},
+		{
+			user:      "nobody",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		user, err := LookupUser(test.user, root)
+		testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expectedUser, user)
+	}
+}
+
+func Test_GetUIDAndGID(t *testing.T) {
+	root := setUpUserFiles(t)
+	defer os.RemoveAll(root)
+	tests := []struct {
+		chown       string
+		expectedUID int
+		expectedGID int
+		shouldErr   bool
+	}{
+		{
+			chown:       "app:staff",
+			expectedUID: 1000,
+			expectedGID: 50,
+		},
+		{
+			// A named user's group is the group with the same name if no group is given
+			chown:       "app",
+			expectedUID: 1000,
+			expectedGID: 1001,
+		},
+		{
+			// There is no group named postgres, so its primary group is used
+			chown:       "postgres",
+			expectedUID: 1002,
+			expectedGID: 1001,
+		},
+		{
+			// A numeric user's uid is used as the gid, whatever the user's primary group is
+			chown:       "1000",
+			expectedUID: 1000,
+			expectedGID: 1000,
+		},
+		{
+			chown:       "1234:5678",
+			expectedUID: 1234,
+			expectedGID: 5678,
+		},
+		{
+			chown:       "1234",
+			expectedUID: 1234,
+			expectedGID: 1234,
+		},
+		{
+			// A uid which isn't in /etc/passwd is used as it is, as it is for USER
+			chown:       "1234:staff",
+			expectedUID: 1234,
+			expectedGID: 50,
+		},
+		{
+			chown:       "app:1234",
+			expectedUID: 1000,
+			expectedGID: 1234,
+		},
+		{
+			chown:       "nobody:staff",
+			expectedUID: -1,
+			expectedGID: -1,
+			shouldErr:   true,
+		},
+		{
+			chown:       "app:nogroup",
+			expectedUID: -1,
+			expectedGID: -1,
+			shouldErr:   true,
+		},
+		{
+			chown:       "app:staff:extra",
+			expectedUID: -1,
+			expectedGID: -1,
+			shouldErr:   true,
+		},
+	}
+	for _, test := range tests {
+		uid, gid, err := GetUIDAndGID(test.chown, root)
+		testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, []int{test.expectedUID, test.expectedGID}, []int{uid, gid})
+	}
+}
+
+func Test_LookupExecUser(t *testing.T) {
+	root := setUpUserFiles(t)
+	defer os.RemoveAll(root)
+	tests := []struct {
+		user         string
+		expectedUser *ExecUser
+		shouldErr    bool
+	}{
+		{
+			user:         "app",
+			expectedUser: &ExecUser{UID: 1000, GID: 1001, Groups: []int{50}, Home: "/home/app"},
+		},
+		{
+			user:         "1000",
+			expectedUser: &ExecUser{UID: 1000, GID: 1001, Groups: []int{50}, Home: "/home/app"},
+		},
+		{
+			// Supplementary groups aren't set if a group is given
+			user:         "app:staff",
+			expectedUser: &ExecUser{UID: 1000, GID: 50, Home: "/home/app"},
+		},
+		{
+			user:         "root",
+			expectedUser: &ExecUser{UID: 0, GID: 0, Home: "/root"},
+		},
+		{
+			user:         "1234",
+			expectedUser: &ExecUser{UID: 1234, GID: 0, Home: "/"},
+		},
+		{
+			user:         "1234:5678",
+			expectedUser: &ExecUser{UID: 1234, GID: 5678, Home: "/"},
+		},
+		{
+			user:      "nobody",
+			shouldErr: true,
+		},
+		{
+			user:      "app:nogroup",
+			shouldErr: true,
+		},
+		{
+			user:      "app:staff:extra",
+			shouldErr: true,
+		},
+	}
+	for _, test := range tests {
+		user, err := LookupExecUser(test.user, root)
+		testutil.CheckErrorAndDeepEqual(t, test.shouldErr, err, test.expectedUser, user)
+	}
+}
@@ -19,8 +19,11 @@ package util
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"fmt"
+	"github.com/GoogleCloudPlatform/kaniko/pkg/constants"
 	"github.com/pkg/errors"
 	"github.com/sirupsen/logrus"
+	"hash/crc32"
 	"io"
 	"os"
 )
@@ -35,17 +38,32 @@ func SetLogLevel(logLevel string) error {
 	return nil
 }
 
+// SetLogFormat sets the format logrus writes logs in, constants.LogFormatText or constants.LogFormatJSON
+func SetLogFormat(logFormat string) error {
+	switch logFormat {
+	case constants.LogFormatText:
+		logrus.SetFormatter(&logrus.TextFormatter{})
+	case constants.LogFormatJSON:
+		logrus.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return errors.Errorf("%s is not a valid log format", logFormat)
+	}
+	return nil
+}
+
+// castagnoli is the table for CRC-32C, which most CPUs compute in hardware
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
 // Hasher returns a hash function, used in snapshotting to determine if a file has changed
+// The hash only needs to tell whether a file changed since the previous snapshot, and the mode, mtime and size
+// of the file are hashed along with its contents, so contents are hashed with CRC-32C, which is many times faster than md5.
 func Hasher() func(string) (string, error) {
 	hasher := func(p string) (string, error) {
-		h := md5.New()
 		fi, err := os.Lstat(p)
 		if err != nil {
 			return "", err
 		}
-		h.Write([]byte(fi.Mode().String()))
-		h.Write([]byte(fi.ModTime().String()))
-
+		h := crc32.New(castagnoli)
 		if fi.Mode().IsRegular() {
 			f, err := os.Open(p)
 			if err != nil {
@@ -56,8 +74,7 @@ func Hasher() func(string) (string, error) {
 				return "", err
 			}
 		}
-
-		return hex.EncodeToString(h.Sum(nil)), nil
+		return fmt.Sprintf("%s:%d:%d:%08x", fi.Mode(), fi.ModTime().UnixNano(), fi.Size(), h.Sum32()), nil
 	}
 	return hasher
 }
@@ -75,3 +92,36 @@ func MtimeHasher() func(string) (string, error) {
 	}
 	return hasher
 }
+
+// CacheHasher returns a hash function, which looks at file contents and permissions but not mtime,
+// so that the same files produce the same cache key across builds
+func CacheHasher() func(string) (string, error) {
+	hasher := func(p string) (string, error) {
+		h := md5.New()
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte(fi.Mode().String()))
+
+		if fi.Mode()&os.ModeSymlink != 0 {
+			link, err := os.Readlink(p)
+			if err != nil {
+				return "", err
+			}
+			h.Write([]byte(link))
+		} else if fi.Mode().IsRegular() {
+			f, err := os.Open(p)
+			if err != nil {
+				return "", err
+			}
+			defer f.Close()
+			if _, err := io.Copy(h, f); err != nil {
+				return "", err
+			}
+		}
+
+		return hex.EncodeToString(h.Sum(nil)), nil
+	}
+	return hasher
+}
@@ -0,0 +1,285 @@
+/*
+Copyright 2018 Google LLC
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package testutil
+
+import (
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+
+	digest "github.com/opencontainers/go-digest"
+)
+
+var (
+	blobPath     = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[a-f0-9]+)$`)
+	uploadsPath  = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/$`)
+	uploadPath   = regexp.MustCompile(`^/v2/(.+)/blobs/uploads/([0-9]+)$`)
+	manifestPath = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+)
+
+// Registry is an in-memory registry serving enough of the Docker Registry HTTP API V2
+// to push and pull images, so that tests don't need access to a real registry
+// Clients must allow insecure registries, since it is served over plain HTTP unless it is started with NewTLSRegistry.
+type Registry struct {
+	server *httptest.Server
+	// username and password are required with basic auth for every request if username is set
+	username string
+	password string
+
+	mu        sync.Mutex
+	blobs     map[string][]byte
+	uploads   map[string][]byte
+	manifests map[string]registryManifest
+	nextID    int
+	// blobDownloads counts the blobs which have been downloaded
+	blobDownloads int
+}
+
+type registryManifest struct {
+	content   []byte
+	mediaType string
+}
+
+// NewRegistry starts an in-memory registry, which should be stopped with Close
+func NewRegistry() *Registry {
+	r := newRegistry()
+	r.server = httptest.NewServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+func newRegistry() *Registry {
+	return &Registry{
+		blobs:     make(map[string][]byte),
+		uploads:   make(map[string][]byte),
+		manifests: make(map[string]registryManifest),
+	}
+}
+
+// NewTLSRegistry starts an in-memory registry served over HTTPS with a self-signed certificate, which is returned by Certificate
+func NewTLSRegistry() *Registry {
+	r := newRegistry()
+	r.server = httptest.NewTLSServer(http.HandlerFunc(r.serveHTTP))
+	return r
+}
+
+// NewRegistryWithAuth starts an in-memory registry which requires basic auth with username and password
+func NewRegistryWithAuth(username, password string) *Registry {
+	r := NewRegistry()
+	r.username = username
+	r.password = password
+	return r
+}
+
+// Host returns the host:port of the registry, for use in image references
+func (r *Registry) Host() string {
+	return strings.TrimPrefix(strings.TrimPrefix(r.server.URL, "http://"), "https://")
+}
+
+// Certificate returns the PEM encoded certificate of a registry started with NewTLSRegistry
+func (r *Registry) Certificate() []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: r.server.Certificate().Raw})
+}
+
+// Close stops the registry
+func (r *Registry) Close() {
+	r.server.Close()
+}
+
+// Tags returns the tags which have been pushed to the repository name
+func (r *Registry) Tags(name string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var tags []string
+	for key := range r.manifests {
+		repo, ref := splitManifestKey(key)
+		if repo == name && !strings.HasPrefix(ref, "sha256:") {
+			tags = append(tags, ref)
+		}
+	}
+	return tags
+}
+
+// AddBlob adds a blob to the registry, and returns its digest
+func (r *Registry) AddBlob(content []byte) digest.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dgst := digest.FromBytes(content)
+	r.blobs[dgst.String()] = content
+	return dgst
+}
+
+// BlobDownloads returns the number of times a blob has been downloaded from the registry
+func (r *Registry) BlobDownloads() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.blobDownloads
+}
+
+// AddManifest adds a manifest to the repository name, tagged with tag
+func (r *Registry) AddManifest(name, tag string, content []byte, mediaType string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	m := registryManifest{
+		content:   content,
+		mediaType: mediaType,
+	}
+	r.manifests[name+"@"+tag] = m
+	r.manifests[name+"@"+digest.FromBytes(content).String()] = m
+}
+
+func (r *Registry) serveHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	w.Header().Set("Docker-Distribution-API-Version", "registry/2.0")
+	if r.username != "" {
+		if username, password, ok := req.BasicAuth(); !ok || username != r.username || password != r.password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			registryError(w, http.StatusUnauthorized, "UNAUTHORIZED", "authentication required")
+			return
+		}
+	}
+	path := req.URL.Path
+	switch {
+	case path == "/v2/" || path == "/v2":
+		w.WriteHeader(http.StatusOK)
+	case blobPath.MatchString(path):
+		r.serveBlob(w, req, blobPath.FindStringSubmatch(path)[2])
+	case uploadsPath.MatchString(path) && req.Method == "POST":
+		r.startUpload(w, uploadsPath.FindStringSubmatch(path)[1])
+	case uploadPath.MatchString(path):
+		match := uploadPath.FindStringSubmatch(path)
+		r.serveUpload(w, req, match[1], match[2])
+	case manifestPath.MatchString(path):
+		match := manifestPath.FindStringSubmatch(path)
+		r.serveManifest(w, req, match[1], match[2])
+	default:
+		registryError(w, http.StatusNotFound, "UNSUPPORTED", path)
+	}
+}
+
+func (r *Registry) serveBlob(w http.ResponseWriter, req *http.Request, dgst string) {
+	b, ok := r.blobs[dgst]
+	if !ok {
+		registryError(w, http.StatusNotFound, "BLOB_UNKNOWN", dgst)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(len(b)))
+	w.Header().Set("Docker-Content-Digest", dgst)
+	w.WriteHeader(http.StatusOK)
+	if req.Method == "GET" {
+		r.blobDownloads++
+		w.Write(b)
+	}
+}
+
+func (r *Registry) startUpload(w http.ResponseWriter, name string) {
+	r.nextID++
+	id := strconv.Itoa(r.nextID)
+	r.uploads[id] = []byte{}
+	w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id))
+	w.Header().Set("Docker-Upload-UUID", id)
+	w.Header().Set("Range", "0-0")
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (r *Registry) serveUpload(w http.ResponseWriter, req *http.Request, name, id string) {
+	content, ok := r.uploads[id]
+	if !ok {
+		registryError(w, http.StatusNotFound, "BLOB_UPLOAD_UNKNOWN", id)
+		return
+	}
+	b, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		registryError(w, http.StatusBadRequest, "BLOB_UPLOAD_INVALID", err.Error())
+		return
+	}
+	content = append(content, b...)
+	switch req.Method {
+	case "PATCH":
+		r.uploads[id] = content
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/uploads/%s", name, id))
+		w.Header().Set("Range", fmt.Sprintf("0-%d", len(content)-1))
+		w.WriteHeader(http.StatusAccepted)
+	case "PUT":
+		dgst := req.URL.Query().Get("digest")
+		if digest.FromBytes(content).String() != dgst {
+			registryError(w, http.StatusBadRequest, "DIGEST_INVALID", dgst)
+			return
+		}
+		delete(r.uploads, id)
+		r.blobs[dgst] = content
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/blobs/%s", name, dgst))
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		registryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", req.Method)
+	}
+}
+
+func (r *Registry) serveManifest(w http.ResponseWriter, req *http.Request, name, ref string) {
+	key := name + "@" + ref
+	switch req.Method {
+	case "GET", "HEAD":
+		m, ok := r.manifests[key]
+		if !ok {
+			registryError(w, http.StatusNotFound, "MANIFEST_UNKNOWN", ref)
+			return
+		}
+		w.Header().Set("Content-Type", m.mediaType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(m.content)))
+		w.Header().Set("Docker-Content-Digest", digest.FromBytes(m.content).String())
+		w.WriteHeader(http.StatusOK)
+		if req.Method == "GET" {
+			w.Write(m.content)
+		}
+	case "PUT":
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			registryError(w, http.StatusBadRequest, "MANIFEST_INVALID", err.Error())
+			return
+		}
+		dgst := digest.FromBytes(b).String()
+		m := registryManifest{
+			content:   b,
+			mediaType: req.Header.Get("Content-Type"),
+		}
+		r.manifests[key] = m
+		r.manifests[name+"@"+dgst] = m
+		w.Header().Set("Location", fmt.Sprintf("/v2/%s/manifests/%s", name, dgst))
+		w.Header().Set("Docker-Content-Digest", dgst)
+		w.WriteHeader(http.StatusCreated)
+	default:
+		registryError(w, http.StatusMethodNotAllowed, "UNSUPPORTED", req.Method)
+	}
+}
+
+func splitManifestKey(key string) (string, string) {
+	i := strings.LastIndex(key, "@")
+	return key[:i], key[i+1:]
+}
+
+func registryError(w http.ResponseWriter, status int, code, detail string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, `{"errors":[{"code":%q,"message":%q}]}`, code, detail)
+}